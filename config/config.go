@@ -21,27 +21,158 @@ type LogConfig struct {
 	Level        string `json:"level"`        //日志级别
 }
 
+// RouteConfig 路由规则配置
+type RouteConfig struct {
+	Name         string             `json:"name"`         //名称
+	Match        string             `json:"match"`        //匹配方式:prefix、exact、regex，默认为prefix
+	Path         string             `json:"path"`         //匹配的路径、前缀或正则表达式
+	Target       string             `json:"target"`       //转发的目标URL
+	Host         string             `json:"host"`         //匹配的Host，支持*.example.com形式的通配
+	Methods      []string           `json:"methods"`      //匹配的请求方法
+	Headers      map[string]string  `json:"headers"`      //匹配的请求头，值为*时存在即匹配
+	Query        map[string]string  `json:"query"`        //匹配的查询参数，值为*时存在即匹配
+	StripPrefix  string             `json:"stripPrefix"`  //从转发URL的路径中去除的前缀
+	AddPrefix    string             `json:"addPrefix"`    //在转发URL的路径前添加的前缀
+	SetHeaders   map[string]string  `json:"setHeaders"`   //转发时设置的请求头，值为空时删除
+	PreserveHost bool               `json:"preserveHost"` //转发时保留客户端请求的Host
+	HostHeader   string             `json:"hostHeader"`   //转发时使用的Host，优先于preserveHost
+	Targets      []string           `json:"targets"`      //多个上游目标URL，配置后替代target
+	Balance      string             `json:"balance"`      //负载均衡方式:round-robin、least-conn、hash，默认为round-robin
+	HashHeader   string             `json:"hashHeader"`   //hash方式下标识客户端的请求头，默认为X-Forwarded-For
+	HealthCheck  *HealthCheckConfig `json:"healthCheck"`  //上游健康检查，为空时不检查
+	Rewrite      *RewriteConfig     `json:"rewrite"`      //响应重写，为空时不重写
+	Policy       *PolicyConfig      `json:"policy"`       //路由的安全策略，与全局策略同时生效
+}
+
+// RewriteConfig 响应重写配置，将响应中指向上游的地址改为客户端访问InBound的地址
+type RewriteConfig struct {
+	PublicURL string   `json:"publicUrl"` //客户端访问InBound的地址，为空时按请求的Host确定
+	Location  bool     `json:"location"`  //重写Location、Content-Location
+	Cookie    bool     `json:"cookie"`    //重写Set-Cookie的Domain、Path
+	Body      bool     `json:"body"`      //重写文本内容中的绝对URL
+	BodyTypes []string `json:"bodyTypes"` //重写内容的Content-Type，为空时使用默认的文本类型
+}
+
+// PolicyConfig 安全策略配置，OutBound执行上游请求前检查
+type PolicyConfig struct {
+	Methods      []string `json:"methods"`      //允许的请求方法，为空时不限制
+	AllowPaths   []string `json:"allowPaths"`   //允许的路径(正则表达式)，为空时不限制
+	DenyPaths    []string `json:"denyPaths"`    //禁止的路径(正则表达式)，优先于allowPaths
+	MaxBodySize  int64    `json:"maxBodySize"`  //请求Body长度上限(字节)，0表示不限制
+	ContentTypes []string `json:"contentTypes"` //允许的请求Content-Type，为空时不限制
+	DenyHeaders  []string `json:"denyHeaders"`  //禁止出现的请求头
+}
+
+// InspectConfig 内容检查配置
+type InspectConfig struct {
+	Request    bool               `json:"request"`    //InBound检查请求Body
+	Response   bool               `json:"response"`   //OutBound检查响应Body
+	MaxSize    int64              `json:"maxSize"`    //检查的内容长度上限(字节)，超出时拦截，默认10MB
+	Inspectors []*InspectorConfig `json:"inspectors"` //按顺序执行的检查器
+}
+
+// InspectorConfig 检查器配置
+type InspectorConfig struct {
+	Name    string         `json:"name"`    //名称，用于日志
+	Type    string         `json:"type"`    //类型:command、icap、regex
+	Command []string       `json:"command"` //command类型执行的命令及参数
+	URL     string         `json:"url"`     //icap类型的服务地址
+	Timeout int            `json:"timeout"` //command、icap类型的超时时间(ms)
+	Rules   []*InspectRule `json:"rules"`   //regex类型的规则
+}
+
+// InspectRule regex检查器的规则
+type InspectRule struct {
+	Pattern     string `json:"pattern"`     //正则表达式
+	Keyword     string `json:"keyword"`     //关键字，配置后替代pattern
+	Action      string `json:"action"`      //处理方式:block、redact，默认为block
+	Replacement string `json:"replacement"` //redact时的替换内容，默认为***
+}
+
+// TLSConfig InBound监听的TLS配置
+type TLSConfig struct {
+	CertFile       string   `json:"certFile"`       //证书文件(PEM)
+	KeyFile        string   `json:"keyFile"`        //私钥文件(PEM)
+	MinVersion     string   `json:"minVersion"`     //最低TLS版本:1.0、1.1、1.2、1.3，默认为1.2
+	CipherSuites   []string `json:"cipherSuites"`   //TLS 1.2及以下版本允许的加密套件，为空时使用默认值
+	ClientCAFile   string   `json:"clientCaFile"`   //校验客户端证书的CA证书文件，配置后启用mTLS
+	ClientAuth     string   `json:"clientAuth"`     //客户端证书校验方式:require、optional，默认为require
+	IdentityHeader string   `json:"identityHeader"` //转发客户端证书标识的请求头
+	ReloadInterval int      `json:"reloadInterval"` //检查证书文件变化的间隔(ms)，默认为60000，小于0时不重新加载
+}
+
+// HealthCheckConfig 上游健康检查配置
+type HealthCheckConfig struct {
+	Path      string `json:"path"`      //检查的路径
+	Interval  int    `json:"interval"`  //检查间隔(ms)
+	Timeout   int    `json:"timeout"`   //检查超时时间(ms)
+	Unhealthy int    `json:"unhealthy"` //连续失败多少次后移出轮转
+	Healthy   int    `json:"healthy"`   //连续成功多少次后恢复轮转
+}
+
 // Config 配置信息
 type Config struct {
-	Port    int `json:"port"`    //监听端口
-	Timeout int `json:"timeout"` //超时时间
-	//MonitoringMode    string            `json:"monitoringMode"`   //文件监控模式
+	Port              int    `json:"port"`              //监听端口
+	Timeout           int    `json:"timeout"`           //超时时间
+	MonitoringMode    string `json:"monitoringMode"`    //文件监控模式(notify:文件系统事件,poll:定时扫描)
 	FileScanInterval  int    `json:"fileScanInterval"`  //文件扫描间隔
 	FileCheckInterval int    `json:"fileCheckInterval"` //检查文件频度
 	KeepFiles         bool   `json:"keepFiles"`         //保存历史文件
+	FileManifest      bool   `json:"fileManifest"`      //file传输时生成清单文件(记录大小及校验和)
+	FileCleanInterval int    `json:"fileCleanInterval"` //传输目录清理间隔(ms)
+	FileMaxAge        int    `json:"fileMaxAge"`        //传输目录中文件最长保留时间(ms)，0表示不限制
+	FileMaxCount      int    `json:"fileMaxCount"`      //传输目录文件数量上限，0表示不限制
+	FileMaxSize       int64  `json:"fileMaxSize"`       //传输目录文件总大小上限(字节)，0表示不限制
+	FileQuarantine    string `json:"fileQuarantine"`    //清理文件时移入的隔离目录，为空时直接删除
+	Encrypt           bool   `json:"encrpt"`            //对传输的数据进行加密
+	Compress          bool   `json:"compress"`          //对传输的数据进行压缩
+	KeyFile           string `json:"keyFile"`           //加密及签名密钥文件(两端使用相同的密钥)
+	Sign              bool   `json:"sign"`              //对传输的数据进行签名
+	ReplayWindow      int    `json:"replayWindow"`      //签名时间戳允许的偏差(ms)
 	InDirectory       string `json:"inDirectory"`       //请求文件保存路径
 	OutDirectory      string `json:"outDirectory"`      //响应文件保存路径
 	InTextTransfer    bool   `json:"inTextTransfer"`    //InBound以文本方式传输
 	OutTextTransfer   bool   `json:"outTextTransfer"`   //OutBound以文本方式传输
 
+	FileRescanInterval int `json:"fileRescanInterval"` //notify模式下补充扫描目录的间隔(ms)，0表示不扫描
+
 	InMonitorHost  string `json:"inMonitorHost"`  //InBound传输监听主机
 	OutMonitorHost string `json:"outMonitorHost"` //OutBound传输监听主机
 	InMonitorPort  int    `json:"inMonitorPort"`  //InBound的传输端口
 	OutMonitorPort int    `json:"outMonitorPort"` //OutBound的传输端口
 
+	TCPHeartbeatInterval int `json:"tcpHeartbeatInterval"` //TCP长连接心跳间隔(ms)，0表示不发送心跳
+	InChunkSize          int `json:"inChunkSize"`          //InBound发送的分组数据长度，0表示使用传输方式的默认值
+	OutChunkSize         int `json:"outChunkSize"`         //OutBound发送的分组数据长度，0表示使用传输方式的默认值
+
+	MaxBufferedBytes int64 `json:"maxBufferedBytes"` //udp、tcp传输接收端缓存的未读取数据总长上限，0表示不限制
+	MaxInFlight      int   `json:"maxInFlight"`      //udp、tcp传输接收端同时接收的消息数量上限，0表示不限制
+	MaxMessageSize   int64 `json:"maxMessageSize"`   //udp、tcp传输单个消息的长度上限，0表示不限制
+
+	JournalDirectory string `json:"journalDirectory"` //处理记录保存目录，为空时不记录
+	JournalRetention int    `json:"journalRetention"` //处理记录保留时间(ms)
+
 	InTransferType  string            `json:"inTransferType"`  //InBound传输类型
 	OutTransferType string            `json:"outTransferType"` //OutBound传输类型
-	URLMapping      map[string]string `json:"urlMapping"`      //URL路径映射
+	URLMapping      map[string]string `json:"urlMapping"`      //URL路径映射(前缀匹配，按前缀由长到短匹配)
+	Routes          []*RouteConfig    `json:"routes"`          //路由规则，按顺序匹配，优先于urlMapping
+	Policy          *PolicyConfig     `json:"policy"`          //全局安全策略，为空时不检查
+	Inspect         *InspectConfig    `json:"inspect"`         //内容检查，为空时不检查
+
+	UpstreamMaxIdleConns        int `json:"upstreamMaxIdleConns"`        //OutBound与上游保持的空闲连接总数上限
+	UpstreamMaxIdleConnsPerHost int `json:"upstreamMaxIdleConnsPerHost"` //OutBound与每个上游主机保持的空闲连接数上限
+	UpstreamIdleTimeout         int `json:"upstreamIdleTimeout"`         //上游空闲连接的保持时间(ms)
+
+	InFECDataShards    int `json:"inFecDataShards"`    //InBound的UDP传输FEC分块中的数据分组数
+	InFECParityShards  int `json:"inFecParityShards"`  //InBound的UDP传输FEC分块中的校验分组数，0表示不启用
+	OutFECDataShards   int `json:"outFecDataShards"`   //OutBound的UDP传输FEC分块中的数据分组数
+	OutFECParityShards int `json:"outFecParityShards"` //OutBound的UDP传输FEC分块中的校验分组数，0表示不启用
+	InUDPByteRate      int `json:"inUdpByteRate"`      //InBound的UDP发送速率上限(字节/秒)，0表示不限制
+	InUDPPacketRate    int `json:"inUdpPacketRate"`    //InBound的UDP发送速率上限(分组/秒)，0表示不限制
+	OutUDPByteRate     int `json:"outUdpByteRate"`     //OutBound的UDP发送速率上限(字节/秒)，0表示不限制
+	OutUDPPacketRate   int `json:"outUdpPacketRate"`   //OutBound的UDP发送速率上限(分组/秒)，0表示不限制
+
+	TLS *TLSConfig `json:"tls"` //InBound监听的TLS配置，为空时使用Http
 
 	Log *LogConfig `json:"log"` //日志配置
 }
@@ -52,24 +183,62 @@ func ParseConfig() (*Config, error) {
 	var GlobalConfig = &Config{
 		Port:              9090,
 		Timeout:           30000,
+		MonitoringMode:    "notify",
 		FileScanInterval:  300,
 		FileCheckInterval: 20,
 		KeepFiles:         true,
+		FileManifest:      false,
+		FileCleanInterval: 60000,
+		FileMaxAge:        0,
+		FileMaxCount:      0,
+		FileMaxSize:       0,
+		FileQuarantine:    "",
+		Encrypt:           false,
+		Compress:          false,
+		KeyFile:           "hgap.key",
+		Sign:              false,
+		ReplayWindow:      60000,
 		InDirectory:       "in/req",
 		OutDirectory:      "out/resp",
 		InTextTransfer:    false,
 		OutTextTransfer:   false,
 
+		FileRescanInterval: 5000,
+
 		InMonitorHost:  "0.0.0.0",
 		InMonitorPort:  9091,
 		OutMonitorHost: "0.0.0.0",
 		OutMonitorPort: 9092,
 
+		TCPHeartbeatInterval: 5000,
+		InChunkSize:          0,
+		OutChunkSize:         0,
+
+		MaxBufferedBytes: 0,
+		MaxInFlight:      0,
+		MaxMessageSize:   0,
+
+		JournalDirectory: path.Join("data", "journal"),
+		JournalRetention: 24 * 60 * 60 * 1000, // 24小时
+
 		InTransferType:  "file",
 		OutTransferType: "file",
 		URLMapping:      map[string]string{
 			// "/": "http://www.baidu.com",
 		},
+
+		UpstreamMaxIdleConns:        100,
+		UpstreamMaxIdleConnsPerHost: 16,
+		UpstreamIdleTimeout:         90000,
+
+		InFECDataShards:    10,
+		InFECParityShards:  0,
+		OutFECDataShards:   10,
+		OutFECParityShards: 0,
+		InUDPByteRate:      0,
+		InUDPPacketRate:    0,
+		OutUDPByteRate:     0,
+		OutUDPPacketRate:   0,
 		Log: &LogConfig{
 			Output:       "stdout,file",
 			Rotate:       true,
@@ -0,0 +1,171 @@
+package fec
+
+import (
+	"errors"
+)
+
+// MaxShards 单个分块中数据分片或校验分片的最大数量
+const MaxShards = 128
+
+var (
+	expTable [512]byte
+	logTable [256]byte
+)
+
+// 初始化GF(2^8)的指数及对数表，本原多项式为 x^8+x^4+x^3+x^2+1
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(expTable); i++ {
+		expTable[i] = expTable[i-255]
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func inv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// coefficient 校验分片parity中数据分片data的系数，采用Cauchy矩阵保证任意k个分片均可恢复数据
+func coefficient(parity, data int) byte {
+	return inv(byte(parity) ^ byte(MaxShards+data))
+}
+
+// mulAdd dst += c * src
+func mulAdd(dst []byte, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	for i, v := range src {
+		dst[i] ^= mul(c, v)
+	}
+}
+
+// Encode 根据数据分片计算parityCount个校验分片，各数据分片的长度必须相同
+func Encode(data [][]byte, parityCount int) ([][]byte, error) {
+	if len(data) == 0 || len(data) > MaxShards || parityCount > MaxShards {
+		return nil, errors.New("分片数量超出范围")
+	}
+	size := len(data[0])
+	result := make([][]byte, parityCount)
+	for i := range result {
+		result[i] = make([]byte, size)
+		for j, shard := range data {
+			if len(shard) != size {
+				return nil, errors.New("数据分片长度不一致")
+			}
+			mulAdd(result[i], shard, coefficient(i, j))
+		}
+	}
+	return result, nil
+}
+
+// Reconstruct 恢复缺失的数据分片。shards的前dataCount个元素为数据分片，其后为校验分片，缺失的分片为nil，
+// 恢复的数据分片将直接写回shards
+func Reconstruct(shards [][]byte, dataCount int) error {
+	if dataCount <= 0 || dataCount > MaxShards || len(shards) < dataCount || len(shards)-dataCount > MaxShards {
+		return errors.New("分片数量超出范围")
+	}
+
+	//选取dataCount个可用分片，构造对应的编码矩阵
+	size := -1
+	rows := make([]int, 0, dataCount)
+	missing := 0
+	for i, shard := range shards {
+		if i < dataCount && shard == nil {
+			missing++
+		}
+		if shard != nil && len(rows) < dataCount {
+			if size >= 0 && len(shard) != size {
+				return errors.New("分片长度不一致")
+			}
+			size = len(shard)
+			rows = append(rows, i)
+		}
+	}
+	if missing == 0 {
+		return nil
+	}
+	if len(rows) < dataCount {
+		return errors.New("可用分片不足，无法恢复数据")
+	}
+
+	matrix := make([][]byte, dataCount)
+	for r, index := range rows {
+		matrix[r] = make([]byte, dataCount)
+		if index < dataCount {
+			matrix[r][index] = 1
+		} else {
+			for j := 0; j < dataCount; j++ {
+				matrix[r][j] = coefficient(index-dataCount, j)
+			}
+		}
+	}
+	inverse, err := invert(matrix)
+	if err != nil {
+		return err
+	}
+
+	for j := 0; j < dataCount; j++ {
+		if shards[j] != nil {
+			continue
+		}
+		shard := make([]byte, size)
+		for r, index := range rows {
+			mulAdd(shard, shards[index], inverse[j][r])
+		}
+		shards[j] = shard
+	}
+	return nil
+}
+
+// invert 高斯-约旦消元求逆矩阵
+func invert(matrix [][]byte) ([][]byte, error) {
+	n := len(matrix)
+	work := make([][]byte, n)
+	for i := range matrix {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], matrix[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errors.New("编码矩阵不可逆")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		c := inv(work[col][col])
+		for k := range work[col] {
+			work[col][k] = mul(work[col][k], c)
+		}
+		for r := 0; r < n; r++ {
+			if r != col && work[r][col] != 0 {
+				mulAdd(work[r], work[col], work[r][col])
+			}
+		}
+	}
+	result := make([][]byte, n)
+	for i := range work {
+		result[i] = work[i][n:]
+	}
+	return result, nil
+}
@@ -0,0 +1,71 @@
+package fec
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestReconstruct(t *testing.T) {
+	tests := []struct {
+		data   int
+		parity int
+		lost   []int //丢失的分片序号，大于等于data的为校验分片
+		err    bool
+	}{
+		{4, 2, nil, false},
+		{4, 2, []int{0}, false},
+		{4, 2, []int{3}, false},
+		{4, 2, []int{1, 2}, false},
+		{4, 2, []int{0, 4}, false},
+		{4, 2, []int{4, 5}, false},
+		{4, 2, []int{0, 1, 2}, true},
+		{1, 1, []int{0}, false},
+		{10, 3, []int{0, 5, 9}, false},
+		{MaxShards / 2, MaxShards / 2, []int{0, 1, 2, 3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d+%d丢失%v", tt.data, tt.parity, tt.lost), func(t *testing.T) {
+			data := make([][]byte, tt.data)
+			for i := range data {
+				data[i] = bytes.Repeat([]byte{byte(i*31 + 7)}, 16)
+				data[i][i%16] = byte(i)
+			}
+			parities, err := Encode(data, tt.parity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shards := append(append([][]byte(nil), data...), parities...)
+			for _, i := range tt.lost {
+				shards[i] = nil
+			}
+			err = Reconstruct(shards, tt.data)
+			if tt.err {
+				if err == nil {
+					t.Error("可用分片不足时应返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range data {
+				if !bytes.Equal(shards[i], data[i]) {
+					t.Errorf("分片%d恢复为%v, 期望%v", i, shards[i], data[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	if _, err := Encode(nil, 1); err == nil {
+		t.Error("没有数据分片时应返回错误")
+	}
+	if _, err := Encode([][]byte{{1, 2}, {3}}, 1); err == nil {
+		t.Error("数据分片长度不一致时应返回错误")
+	}
+	if _, err := Encode([][]byte{{1}}, MaxShards+1); err == nil {
+		t.Error("校验分片数量超出上限时应返回错误")
+	}
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// 头部定义
+const (
+	RequestIDHeader = "X-Hgap-Request-Id" //请求标识
+	ErrorHeader     = "X-Hgap-Error"      //网关错误原因
+)
+
+// 网关错误原因
+const (
+	ReasonNoRoute         = "no_route"          //无匹配的转发路径
+	ReasonNoUpstream      = "no_upstream"       //路由的上游均不可用
+	ReasonBadRequest      = "bad_request"       //请求数据无法读取或解析
+	ReasonPolicyDenied    = "policy_denied"     //违反OutBound的安全策略
+	ReasonContentBlocked  = "content_blocked"   //内容检查未通过
+	ReasonTooLarge        = "message_too_large" //消息长度超出接收上限
+	ReasonOverloaded      = "overloaded"        //接收端缓存已满
+	ReasonUpstreamError   = "upstream_error"    //执行上游请求出错
+	ReasonUpstreamTimeout = "upstream_timeout"  //上游请求超时
+	ReasonTransferError   = "transfer_error"    //数据传输出错
+	ReasonGatewayTimeout  = "gateway_timeout"   //等待响应超时
+	ReasonBadResponse     = "bad_response"      //响应数据无法读取或解析
+)
+
+// errorBody 网关错误响应内容
+type errorBody struct {
+	Status    int    `json:"status"`
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId"`
+}
+
+func errorContent(status int, reason string, reqID string, message string) []byte {
+	content, _ := json.Marshal(&errorBody{
+		Status:    status,
+		Error:     reason,
+		Message:   message,
+		RequestID: reqID,
+	})
+	return content
+}
+
+func setErrorHeader(header http.Header, reason string, reqID string, length int) {
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(length))
+	header.Set(ErrorHeader, reason)
+	header.Set(RequestIDHeader, reqID)
+}
+
+// ErrorResponse 构造网关错误响应，用于通过Transfer发送
+func ErrorResponse(status int, reason string, reqID string, message string) *http.Response {
+	content := errorContent(status, reason, reqID, message)
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          ioutil.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)),
+	}
+	setErrorHeader(resp.Header, reason, reqID, len(content))
+	return resp
+}
+
+// WriteError 直接输出网关错误响应
+func WriteError(w http.ResponseWriter, status int, reason string, reqID string, message string) {
+	content := errorContent(status, reason, reqID, message)
+	setErrorHeader(w.Header(), reason, reqID, len(content))
+	w.WriteHeader(status)
+	w.Write(content)
+}
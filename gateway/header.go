@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 代理头部
+const (
+	ForwardedForHeader   = "X-Forwarded-For"   //客户端及经过的代理地址
+	ForwardedProtoHeader = "X-Forwarded-Proto" //客户端访问的协议
+	ForwardedHostHeader  = "X-Forwarded-Host"  //客户端访问的Host
+	ViaHeader            = "Via"               //经过的代理
+	ClientCertHeader     = "X-Client-Cert"     //InBound校验的客户端证书标识
+)
+
+// ViaName 网关在Via头部中的名称
+const ViaName = "hgap"
+
+// hopHeaders 逐跳头部，代理转发时不应传递(RFC 7230 6.1)
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopByHop 删除逐跳头部，包括Connection头中列出的头部
+func RemoveHopByHop(header http.Header) {
+	for _, v := range header["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	RemoveHopHeaders(header)
+}
+
+// RemoveHopHeaders 只删除标准的逐跳头部
+func RemoveHopHeaders(header http.Header) {
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// CopyHeader 复制全部头部及其所有取值
+func CopyHeader(dst http.Header, src http.Header) {
+	for h, val := range src {
+		for _, v := range val {
+			dst.Add(h, v)
+		}
+	}
+}
+
+// AddForwarded 添加X-Forwarded-For/Proto/Host及Via头部，客户端地址追加至已有的X-Forwarded-For之后
+func AddForwarded(r *http.Request) {
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Values(ForwardedForHeader); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		r.Header.Set(ForwardedForHeader, ip)
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	r.Header.Set(ForwardedProtoHeader, proto)
+	r.Header.Set(ForwardedHostHeader, r.Host)
+	r.Header.Add(ViaHeader, fmt.Sprintf("%d.%d %s", r.ProtoMajor, r.ProtoMinor, ViaName))
+}
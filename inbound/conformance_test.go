@@ -0,0 +1,269 @@
+package inbound
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/config"
+	"github.com/jamsa/hgap/gateway"
+	"github.com/jamsa/hgap/outbound"
+)
+
+// upstreamHandler 一致性测试使用的上游服务
+func upstreamHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(r.URL.Query().Get("code"))
+		w.Header().Set("X-Code", strconv.Itoa(code))
+		if code == http.StatusFound {
+			w.Header().Set("Location", "/target")
+		}
+		w.WriteHeader(code)
+		if code != http.StatusNoContent && code != http.StatusNotModified {
+			fmt.Fprintf(w, "status %d", code)
+		}
+	})
+	mux.HandleFunc("/cookies", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1; Path=/")
+		w.Header().Add("Set-Cookie", "b=2; Path=/; HttpOnly")
+		w.Header().Add("X-Multi", "one")
+		w.Header().Add("X-Multi", "two")
+		w.Write([]byte("cookies"))
+	})
+	mux.HandleFunc("/trailer", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Sum, X-Count")
+		w.Write([]byte("body with trailers"))
+		w.(http.Flusher).Flush()
+		w.Header().Set("X-Sum", "abc")
+		w.Header().Set("X-Count", "18")
+	})
+	mux.HandleFunc("/hop", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-End", "kept")
+		w.Write([]byte("hop"))
+	})
+	mux.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.Header)
+	})
+	return mux
+}
+
+// startPair 在临时目录中启动file传输方式的InBound及OutBound，返回InBound的监听服务
+func startPair(t *testing.T, upstream string) *httptest.Server {
+	dir, err := ioutil.TempDir("", "hgap-conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//目录中没有config.json，使用默认配置
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.ParseConfig()
+	os.Chdir(wd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.InDirectory = filepath.Join(dir, "in", "req")
+	cfg.OutDirectory = filepath.Join(dir, "out", "resp")
+	cfg.JournalDirectory = ""
+	cfg.KeepFiles = false
+	cfg.Timeout = 10000
+	cfg.URLMapping = map[string]string{"/": upstream + "/"}
+
+	out, err := outbound.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go out.Start()
+	inb, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go inb.monitor.Start(inb.notify)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(inb.index))
+	server.Config.ConnContext = saveConn
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// result 用于比较的响应内容
+type result struct {
+	status  int
+	header  http.Header
+	body    string
+	trailer http.Header
+}
+
+func call(t *testing.T, method string, url string, header http.Header) result {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//与传输相关，直连与代理可能不同的头部
+	resp.Header.Del("Date")
+	return result{status: resp.StatusCode, header: resp.Header, body: string(body), trailer: resp.Trailer}
+}
+
+func TestConformance(t *testing.T) {
+	log.SetLevel(log.WarnLevel)
+	upstream := httptest.NewServer(upstreamHandler())
+	defer upstream.Close()
+	proxy := startPair(t, upstream.URL)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"ok", "GET", "/status?code=200"},
+		{"created", "POST", "/status?code=201"},
+		{"no content", "GET", "/status?code=204"},
+		{"found", "GET", "/status?code=302"},
+		{"not modified", "GET", "/status?code=304"},
+		{"not found", "GET", "/status?code=404"},
+		{"server error", "GET", "/status?code=500"},
+		{"unavailable", "GET", "/status?code=503"},
+		{"head", "HEAD", "/status?code=200"},
+		{"set-cookie", "GET", "/cookies"},
+		{"trailer", "GET", "/trailer"},
+		{"hop-by-hop", "GET", "/hop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			direct := call(t, tt.method, upstream.URL+tt.path, nil)
+			proxied := call(t, tt.method, proxy.URL+tt.path, nil)
+
+			if proxied.header.Get(gateway.RequestIDHeader) == "" {
+				t.Errorf("缺少%s头部", gateway.RequestIDHeader)
+			}
+			proxied.header.Del(gateway.RequestIDHeader)
+			//代理不应传递逐跳头部
+			gateway.RemoveHopByHop(direct.header)
+			gateway.RemoveHopByHop(proxied.header)
+
+			if direct.status != proxied.status {
+				t.Errorf("状态码 %d, 期望 %d", proxied.status, direct.status)
+			}
+			if !reflect.DeepEqual(direct.header, proxied.header) {
+				t.Errorf("头部 %v, 期望 %v", proxied.header, direct.header)
+			}
+			if direct.body != proxied.body {
+				t.Errorf("Body %q, 期望 %q", proxied.body, direct.body)
+			}
+			if !reflect.DeepEqual(direct.trailer, proxied.trailer) {
+				t.Errorf("Trailer %v, 期望 %v", proxied.trailer, direct.trailer)
+			}
+		})
+	}
+}
+
+func TestHopByHopResponseHeaders(t *testing.T) {
+	log.SetLevel(log.WarnLevel)
+	upstream := httptest.NewServer(upstreamHandler())
+	defer upstream.Close()
+	proxy := startPair(t, upstream.URL)
+
+	resp := call(t, "GET", proxy.URL+"/hop", nil)
+	for _, h := range []string{"X-Hop", "Keep-Alive"} {
+		if v := resp.header.Get(h); v != "" {
+			t.Errorf("逐跳头部%s未删除: %s", h, v)
+		}
+	}
+	if v := resp.header.Get("X-End"); v != "kept" {
+		t.Errorf("端到端头部X-End为%q", v)
+	}
+}
+
+func TestHopByHopRequestHeaders(t *testing.T) {
+	log.SetLevel(log.WarnLevel)
+	upstream := httptest.NewServer(upstreamHandler())
+	defer upstream.Close()
+	proxy := startPair(t, upstream.URL)
+
+	header := http.Header{
+		"Connection": {"X-Req-Hop"},
+		"X-Req-Hop":  {"1"},
+		"X-End":      {"a", "b"},
+	}
+	resp := call(t, "GET", proxy.URL+"/headers", header)
+	if resp.status != http.StatusOK {
+		t.Fatalf("状态码 %d", resp.status)
+	}
+	var received http.Header
+	if err := json.Unmarshal([]byte(resp.body), &received); err != nil {
+		t.Fatal(err)
+	}
+	if v := received.Get("X-Req-Hop"); v != "" {
+		t.Errorf("逐跳头部X-Req-Hop未删除: %s", v)
+	}
+	if v := received["X-End"]; !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Errorf("端到端头部X-End为%v", v)
+	}
+	if v := received.Get(gateway.ViaHeader); v == "" {
+		t.Errorf("缺少%s头部", gateway.ViaHeader)
+	}
+}
+
+func TestHopByHopForwardedHeaders(t *testing.T) {
+	log.SetLevel(log.WarnLevel)
+	upstream := httptest.NewServer(upstreamHandler())
+	defer upstream.Close()
+	proxy := startPair(t, upstream.URL)
+
+	//客户端在Connection中列出的代理头部只删除客户端提供的值，不影响InBound添加的值
+	header := http.Header{
+		"Connection":      {"X-Forwarded-For, Via"},
+		"X-Forwarded-For": {"10.0.0.1"},
+		"Via":             {"1.1 client-proxy"},
+	}
+	resp := call(t, "GET", proxy.URL+"/headers", header)
+	if resp.status != http.StatusOK {
+		t.Fatalf("状态码 %d", resp.status)
+	}
+	var received http.Header
+	if err := json.Unmarshal([]byte(resp.body), &received); err != nil {
+		t.Fatal(err)
+	}
+	if v := received.Get(gateway.ForwardedForHeader); v != "127.0.0.1" {
+		t.Errorf("%s为%q, 期望%q", gateway.ForwardedForHeader, v, "127.0.0.1")
+	}
+	if v := received.Get(gateway.ViaHeader); v != "1.1 hgap" {
+		t.Errorf("%s为%q, 期望%q", gateway.ViaHeader, v, "1.1 hgap")
+	}
+}
@@ -0,0 +1,46 @@
+package inbound
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// connKey 请求上下文中保存客户端连接的key
+type connKey struct{}
+
+// saveConn 在请求上下文中保存客户端连接，用于按数据块设置读写超时
+func saveConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+// deadlineReader 每次读取前延长读超时，上传只在停止传输超过timeout时中断
+type deadlineReader struct {
+	io.ReadCloser
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	return r.ReadCloser.Read(p)
+}
+
+// deadlineWriter 每次写入前延长写超时，下载只在停止传输超过timeout时中断
+type deadlineWriter struct {
+	http.ResponseWriter
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *deadlineWriter) WriteHeader(status int) {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	w.ResponseWriter.WriteHeader(status)
+}
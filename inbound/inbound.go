@@ -2,11 +2,13 @@ package inbound
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,6 +16,9 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/jamsa/hgap/config"
+	"github.com/jamsa/hgap/gateway"
+	"github.com/jamsa/hgap/inspect"
+	"github.com/jamsa/hgap/journal"
 	"github.com/jamsa/hgap/monitor"
 	"github.com/jamsa/hgap/transfer"
 	uuid "github.com/satori/go.uuid"
@@ -21,11 +26,15 @@ import (
 
 // InBound 入站服务
 type InBound struct {
-	port     int                //监听端口
-	monitor  monitor.IMonitor   //监控对象
-	transfer transfer.ITransfer //传输对象
-	requests *sync.Map          //请求map
-	timeout  int                //超时时间
+	port      int                //监听端口
+	monitor   monitor.IMonitor   //监控对象
+	transfer  transfer.ITransfer //传输对象
+	requests  *sync.Map          //请求map
+	timeout   int                //超时时间
+	journal   *journal.Journal   //已发送请求记录
+	inspector *inspect.Pipeline  //请求内容检查，未启用时为nil
+	tls       *certReloader      //TLS证书，未启用TLS时为nil
+	identity  string             //转发客户端证书标识的请求头
 }
 
 type finishChan chan interface{}
@@ -40,12 +49,34 @@ func New(config *config.Config) (*InBound, error) {
 	if err != nil {
 		return nil, err
 	}
+	journal, err := journal.Open("inbound", config)
+	if err != nil {
+		return nil, err
+	}
+	inspector, err := inspect.New(true, config)
+	if err != nil {
+		return nil, err
+	}
+	var reloader *certReloader
+	identity := gateway.ClientCertHeader
+	if config.TLS != nil {
+		if reloader, err = newCertReloader(config.TLS); err != nil {
+			return nil, err
+		}
+		if config.TLS.IdentityHeader != "" {
+			identity = config.TLS.IdentityHeader
+		}
+	}
 	result := &InBound{
-		port:     config.Port,
-		monitor:  monitor,
-		transfer: transfer,
-		requests: &sync.Map{},
-		timeout:  config.Timeout,
+		port:      config.Port,
+		monitor:   monitor,
+		transfer:  transfer,
+		requests:  &sync.Map{},
+		timeout:   config.Timeout,
+		journal:   journal,
+		inspector: inspector,
+		tls:       reloader,
+		identity:  identity,
 	}
 	//monitor.SetOnReady(result.notify)
 	return result, nil
@@ -56,14 +87,24 @@ func (inbound *InBound) Start() {
 	go inbound.monitor.Start(inbound.notify)
 
 	//启动监听服务
+	//不限制整个请求及响应的传输时间，Body按数据块设置超时(见index)，以支持大文件的上传下载
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", inbound.port),
-		ReadTimeout:  time.Duration(inbound.timeout) * time.Millisecond,
-		WriteTimeout: time.Duration(inbound.timeout) * time.Millisecond,
+		Addr:              fmt.Sprintf(":%d", inbound.port),
+		ReadHeaderTimeout: time.Duration(inbound.timeout) * time.Millisecond,
+		IdleTimeout:       time.Duration(inbound.timeout) * time.Millisecond,
+		ConnContext:       saveConn,
 	}
 	http.HandleFunc("/", inbound.index)
-	log.Println("开始监听", inbound.port, "...")
-	err := server.ListenAndServe()
+	var err error
+	if inbound.tls != nil {
+		server.TLSConfig = inbound.tls.tlsConfig()
+		go inbound.tls.watch()
+		log.Println("开始监听(TLS)", inbound.port, "...")
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Println("开始监听", inbound.port, "...")
+		err = server.ListenAndServe()
+	}
 	if err != nil {
 		log.Fatal("监听出错: ", err)
 	}
@@ -74,20 +115,28 @@ func (inbound *InBound) notify(reqID string) {
 	ch, ok := inbound.requests.Load(reqID)
 	if ok {
 		log.Println("发送响应文件通知:" + reqID)
-		ch.(finishChan) <- struct{}{}
+		//通道有缓冲，请求已超时返回时不阻塞
+		select {
+		case ch.(finishChan) <- struct{}{}:
+		default:
+			log.Warn("重复的响应通知:" + reqID)
+		}
+	} else if inbound.journal.Contains(reqID) {
+		//已超时或重启前发送的请求，直接丢弃响应
+		log.Debug("丢弃过期的响应:" + reqID)
+		inbound.monitor.Remove(reqID)
 	} else {
 		log.Warn("文件响应通道不存在:" + reqID)
 	}
 }
 
 // cleanUp 清理
-func (inbound *InBound) cleanUp(reqID string, finish finishChan, timeout *time.Ticker) {
-	timeout.Stop()
+func (inbound *InBound) cleanUp(reqID string) {
 	//delete(reqs, reqID)
 	inbound.requests.Delete(reqID)
 	inbound.transfer.Remove(reqID) //清理发送的请求数据，文件类型的请求数据不能在发送后立即清理
 	inbound.monitor.Remove(reqID)  //清理接收的响应数据
-	close(finish)
+	//不关闭finish，避免与并发的notify竞争导致向已关闭的通道发送
 }
 
 // writeResp 发送响应
@@ -104,23 +153,98 @@ func (inbound *InBound) writeResp(reqID string, respWriter http.ResponseWriter,
 	//inbound.monitor.Remove(reqID)
 	if err != nil {
 		log.Println("读取响应数据", reqID, "出错", err)
+		if errors.Is(err, monitor.ErrOverloaded) {
+			gateway.WriteError(respWriter, http.StatusServiceUnavailable, gateway.ReasonOverloaded, reqID, err.Error())
+			return
+		}
+		gateway.WriteError(respWriter, http.StatusBadGateway, gateway.ReasonBadResponse, reqID, err.Error())
 		return
 	}
+	defer content.Close()
 
-	var buf = bufio.NewReader(strings.NewReader(string(content)))
-	resp, err := http.ReadResponse(buf, request)
+	resp, err := http.ReadResponse(bufio.NewReader(content), request)
 	if err != nil {
 		log.Println("读取Http响应信息出错", err)
+		gateway.WriteError(respWriter, http.StatusBadGateway, gateway.ReasonBadResponse, reqID, err.Error())
 		return
 	}
 	defer resp.Body.Close()
 
-	for h, val := range resp.Header {
-		respWriter.Header().Set(h, val[0])
+	//复制状态码及全部头部，Trailer需在输出状态码前声明
+	header := respWriter.Header()
+	gateway.RemoveHopByHop(resp.Header)
+	gateway.CopyHeader(header, resp.Header)
+	header.Set(gateway.RequestIDHeader, reqID)
+	if len(resp.Trailer) > 0 {
+		trailers := make([]string, 0, len(resp.Trailer))
+		for k := range resp.Trailer {
+			trailers = append(trailers, k)
+		}
+		header.Set("Trailer", strings.Join(trailers, ", "))
+	}
+	respWriter.WriteHeader(resp.StatusCode)
+
+	if _, err = io.Copy(respWriter, resp.Body); err != nil {
+		log.Println("输出响应数据", reqID, "出错", err)
+		return
+	}
+	//Body读取完毕后Trailer的取值才可用
+	for k, val := range resp.Trailer {
+		header[k] = val
 	}
-	b := new(bytes.Buffer)
-	io.Copy(b, resp.Body)
-	respWriter.Write(b.Bytes())
+}
+
+// writeRequest 将请求以Http报文格式写入w，请求Body以流的方式写入
+func writeRequest(w io.Writer, r *http.Request) error {
+	//报文统一按HTTP/1.1格式写出，长度未知的Body(如HTTP/2请求)使用chunked编码
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/1.1", 1, 1
+	if r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody {
+		r.TransferEncoding = []string{"chunked"}
+	}
+	header, err := httputil.DumpRequest(r, false)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+	if r.Body == nil {
+		return nil
+	}
+	chunked := len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked"
+	if !chunked {
+		_, err = io.Copy(w, r.Body)
+		return err
+	}
+	cw := httputil.NewChunkedWriter(w)
+	if _, err = io.Copy(cw, r.Body); err != nil {
+		return err
+	}
+	if err = cw.Close(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\r\n")
+	return err
+}
+
+// inspectRequest 检查请求Body，通过后以检查后的内容替换，被拦截时返回403
+func (inbound *InBound) inspectRequest(reqID string, w http.ResponseWriter, r *http.Request) bool {
+	data, err := inbound.inspector.Inspect(reqID, r.Header, r.Body)
+	if err != nil {
+		var blocked *inspect.Blocked
+		if errors.As(err, &blocked) {
+			gateway.WriteError(w, http.StatusForbidden, gateway.ReasonContentBlocked, reqID, err.Error())
+		} else {
+			log.Error("读取请求数据", reqID, "出错", err)
+			gateway.WriteError(w, http.StatusBadRequest, gateway.ReasonBadRequest, reqID, err.Error())
+		}
+		return false
+	}
+	r.Body = inspect.NewBody(data)
+	r.ContentLength = int64(len(data))
+	r.TransferEncoding = nil
+	r.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return true
 }
 
 func (inbound *InBound) index(w http.ResponseWriter, r *http.Request) {
@@ -129,11 +253,15 @@ func (inbound *InBound) index(w http.ResponseWriter, r *http.Request) {
 			log.Error("请求处理出错", r)
 		}
 	}()
-	content, err := httputil.DumpRequest(r, true)
-	if err != nil {
-		log.Error("保存请求信息出错", err)
-		return
+	//请求及响应Body每个数据块的读写超时为timeout
+	timeout := time.Duration(inbound.timeout) * time.Millisecond
+	conn, _ := r.Context().Value(connKey{}).(net.Conn)
+	if conn != nil {
+		r.Body = &deadlineReader{ReadCloser: r.Body, conn: conn, timeout: timeout}
+		w = &deadlineWriter{ResponseWriter: w, conn: conn, timeout: timeout}
+		defer conn.SetDeadline(time.Time{})
 	}
+
 	uid /*, err*/ := uuid.NewV4()
 	/*if err != nil {
 		log.Error("生成请求uuid出错", err)
@@ -141,24 +269,60 @@ func (inbound *InBound) index(w http.ResponseWriter, r *http.Request) {
 	}*/
 	reqID := uid.String()
 
-	finish := make(finishChan)
+	if inbound.inspector != nil && r.ContentLength != 0 && !inbound.inspectRequest(reqID, w, r) {
+		return
+	}
+
+	finish := make(finishChan, 1)
 	log.Debug("保存响应Channel:" + reqID)
 	inbound.requests.Store(reqID, finish)
-	//超时
-	timeout := time.NewTicker(time.Duration(inbound.timeout) * time.Millisecond)
-	defer inbound.cleanUp(reqID, finish, timeout)
+	inbound.journal.Record(reqID)
+	defer inbound.cleanUp(reqID)
+
+	//逐跳头部只对客户端与InBound之间的连接有效，需在添加代理头部前删除，
+	//以免Connection中列出的X-Forwarded-For、Via等头部在OutBound端被删除
+	gateway.RemoveHopByHop(r.Header)
+	//记录客户端地址及证书标识，由OutBound转发至上游，客户端自行提供的证书标识将被删除
+	r.Header.Del(inbound.identity)
+	if identity := clientIdentity(r.TLS); identity != "" {
+		r.Header.Set(inbound.identity, identity)
+	}
+	gateway.AddForwarded(r)
+
+	//请求数据以流的方式交给transfer发送
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		err := writeRequest(writer, r)
+		if err != nil {
+			log.Error("保存请求信息出错", err)
+		}
+		writer.CloseWithError(err)
+	}()
 
 	log.Println("发送请求:" + reqID)
-	inbound.transfer.Send(reqID, content)
+	if err := inbound.transfer.Send(reqID, reader); err != nil {
+		log.Error("发送请求", reqID, "出错", err)
+		gateway.WriteError(w, http.StatusBadGateway, gateway.ReasonTransferError, reqID, err.Error())
+		return
+	}
 	log.Println("请求发送完成:" + reqID)
 
+	//请求发送完毕后清除读超时，避免等待响应期间连接的后台读取超时
+	if conn != nil {
+		conn.SetReadDeadline(time.Time{})
+	}
+	//请求发送完毕后才开始等待响应的超时
+	wait := time.NewTimer(timeout)
+	defer wait.Stop()
 	select {
 	case <-finish:
 		log.Println("获取响应:" + reqID)
 		inbound.writeResp(reqID, w, r)
-	case <-timeout.C:
+	case <-wait.C:
 		log.Warn("请求处理超时:" + reqID)
 		inbound.monitor.DebugTimeout(reqID)
+		gateway.WriteError(w, http.StatusGatewayTimeout, gateway.ReasonGatewayTimeout, reqID, "等待响应超时")
 		//返回时将自动cleanUp
 	}
 }
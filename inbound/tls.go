@@ -0,0 +1,209 @@
+package inbound
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// tlsVersions 支持配置的TLS最低版本
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader 证书文件变化时重新加载，无需重启服务
+type certReloader struct {
+	cfg     *config.TLSConfig
+	lock    sync.RWMutex
+	config  *tls.Config //当前证书对应的TLS配置
+	modTime time.Time   //已加载文件的最后修改时间
+}
+
+// newCertReloader 校验TLS配置并加载证书
+func newCertReloader(cfg *config.TLSConfig) (*certReloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("启用TLS时需配置certFile及keyFile")
+	}
+	reloader := &certReloader{cfg: cfg}
+	if _, err := reloader.baseConfig(); err != nil {
+		return nil, err
+	}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// baseConfig 按配置创建不含证书的TLS配置
+func (reloader *certReloader) baseConfig() (*tls.Config, error) {
+	cfg := reloader.cfg
+	result := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"}, //请求以HTTP/1.1报文格式传输，不协商h2
+	}
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("TLS版本%s不支持", cfg.MinVersion)
+		}
+		result.MinVersion = version
+	}
+	if len(cfg.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range cfg.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("加密套件%s不支持", name)
+			}
+			result.CipherSuites = append(result.CipherSuites, id)
+		}
+	}
+	if cfg.ClientCAFile != "" {
+		switch cfg.ClientAuth {
+		case "", "require":
+			result.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional":
+			result.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("客户端证书校验方式%s不支持", cfg.ClientAuth)
+		}
+	}
+	return result, nil
+}
+
+// files 需要监视的证书文件
+func (reloader *certReloader) files() []string {
+	files := []string{reloader.cfg.CertFile, reloader.cfg.KeyFile}
+	if reloader.cfg.ClientCAFile != "" {
+		files = append(files, reloader.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// lastModified 证书文件的最后修改时间
+func (reloader *certReloader) lastModified() (time.Time, error) {
+	var result time.Time
+	for _, file := range reloader.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return result, err
+		}
+		if info.ModTime().After(result) {
+			result = info.ModTime()
+		}
+	}
+	return result, nil
+}
+
+// load 加载证书、私钥及客户端CA证书
+func (reloader *certReloader) load() error {
+	modTime, err := reloader.lastModified()
+	if err != nil {
+		return err
+	}
+	result, err := reloader.baseConfig()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.cfg.CertFile, reloader.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("加载证书出错: %v", err)
+	}
+	result.Certificates = []tls.Certificate{cert}
+	if reloader.cfg.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(reloader.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("客户端CA证书文件中没有有效的证书" + reloader.cfg.ClientCAFile)
+		}
+		result.ClientCAs = pool
+	}
+
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		log.Printf("已加载TLS证书%s: %s，有效期至%s", reloader.cfg.CertFile, leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+	}
+	reloader.lock.Lock()
+	reloader.config = result
+	reloader.modTime = modTime
+	reloader.lock.Unlock()
+	return nil
+}
+
+// watch 定时检查证书文件，修改后重新加载，加载失败时继续使用原证书
+func (reloader *certReloader) watch() {
+	interval := time.Duration(reloader.cfg.ReloadInterval) * time.Millisecond
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = time.Minute
+	}
+	for {
+		time.Sleep(interval)
+		modTime, err := reloader.lastModified()
+		if err != nil {
+			log.Warn("检查TLS证书文件出错: ", err)
+			continue
+		}
+		reloader.lock.RLock()
+		changed := !modTime.Equal(reloader.modTime)
+		reloader.lock.RUnlock()
+		if !changed {
+			continue
+		}
+		if err = reloader.load(); err != nil {
+			log.Error("重新加载TLS证书出错，继续使用原证书: ", err)
+		}
+	}
+}
+
+// current 当前证书对应的TLS配置
+func (reloader *certReloader) current() *tls.Config {
+	reloader.lock.RLock()
+	defer reloader.lock.RUnlock()
+	return reloader.config
+}
+
+// tlsConfig 监听使用的TLS配置，每次握手使用最新加载的证书
+func (reloader *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &reloader.current().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return reloader.current(), nil
+		},
+	}
+}
+
+// clientIdentity 已校验的客户端证书标识，未提供证书时为空
+func clientIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	identity := cert.Subject.String()
+	if len(cert.DNSNames) > 0 {
+		identity += ";DNS=" + strings.Join(cert.DNSNames, ",")
+	}
+	return identity
+}
@@ -0,0 +1,95 @@
+package inspect
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// 外部命令的退出码
+const (
+	exitAllow  = 0 //放行
+	exitRedact = 2 //以标准输出的内容替换原内容
+)
+
+// commandInspector 调用外部命令检查内容，内容由标准输入传入
+type commandInspector struct {
+	name    string
+	command []string
+	timeout time.Duration
+}
+
+func newCommandInspector(cfg *config.InspectorConfig) (*commandInspector, error) {
+	if len(cfg.Command) == 0 {
+		return nil, errors.New("未配置command")
+	}
+	inspector := &commandInspector{
+		name:    cfg.Name,
+		command: cfg.Command,
+		timeout: time.Duration(cfg.Timeout) * time.Millisecond,
+	}
+	if inspector.name == "" {
+		inspector.name = cfg.Command[0]
+	}
+	if inspector.timeout <= 0 {
+		inspector.timeout = 30 * time.Second
+	}
+	return inspector, nil
+}
+
+func (inspector *commandInspector) Name() string {
+	return inspector.name
+}
+
+// Inspect 退出码为0时放行，为2时以标准输出替换内容，其它退出码拦截，原因为输出的第一行
+func (inspector *commandInspector) Inspect(item *Item) (*Decision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), inspector.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, inspector.command[0], inspector.command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"HGAP_REQUEST_ID="+item.ReqID,
+		"HGAP_DIRECTION="+item.Direction,
+		"HGAP_CONTENT_TYPE="+item.ContentType,
+	)
+	cmd.Stdin = bytes.NewReader(item.Data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, errors.New("检查命令执行超时")
+	}
+	code := 0
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return nil, err
+		}
+		code = exitErr.ExitCode()
+	}
+	switch code {
+	case exitAllow:
+		return &Decision{Action: ActionAllow}, nil
+	case exitRedact:
+		return &Decision{Action: ActionRedact, Reason: firstLine(stderr.String(), "内容已替换"), Data: stdout.Bytes()}, nil
+	default:
+		reason := firstLine(stdout.String(), firstLine(stderr.String(), "检查命令拒绝"))
+		return &Decision{Action: ActionBlock, Reason: reason}, nil
+	}
+}
+
+// firstLine 输出的第一个非空行，没有时返回def
+func firstLine(output string, def string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return def
+}
@@ -0,0 +1,117 @@
+package inspect
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// icapViolationHeaders ICAP服务返回的拦截原因头部
+var icapViolationHeaders = []string{"X-Infection-Found", "X-Violations-Found", "X-Virus-ID", "X-Block-Reason"}
+
+// icapInspector 通过ICAP(RFC 3507)服务检查内容，以RESPMOD方式提交
+type icapInspector struct {
+	name    string
+	url     *url.URL
+	timeout time.Duration
+}
+
+func newICAPInspector(cfg *config.InspectorConfig) (*icapInspector, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "icap" || u.Host == "" {
+		return nil, errors.New("ICAP地址需为icap://host:port/service形式")
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "1344")
+	}
+	inspector := &icapInspector{
+		name:    cfg.Name,
+		url:     u,
+		timeout: time.Duration(cfg.Timeout) * time.Millisecond,
+	}
+	if inspector.name == "" {
+		inspector.name = "icap"
+	}
+	if inspector.timeout <= 0 {
+		inspector.timeout = 30 * time.Second
+	}
+	return inspector, nil
+}
+
+func (inspector *icapInspector) Name() string {
+	return inspector.name
+}
+
+// Inspect 服务返回204时放行，返回200(内容被修改)时拦截
+func (inspector *icapInspector) Inspect(item *Item) (*Decision, error) {
+	conn, err := net.DialTimeout("tcp", inspector.url.Host, inspector.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(inspector.timeout))
+
+	contentType := item.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	resHdr := "HTTP/1.1 200 OK\r\n" +
+		"Content-Type: " + contentType + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(item.Data)) + "\r\n\r\n"
+	writer := bufio.NewWriter(conn)
+	fmt.Fprintf(writer, "RESPMOD %s ICAP/1.0\r\n", inspector.url.String())
+	fmt.Fprintf(writer, "Host: %s\r\n", inspector.url.Host)
+	fmt.Fprintf(writer, "Allow: 204\r\n")
+	fmt.Fprintf(writer, "X-Hgap-Request-Id: %s\r\n", item.ReqID)
+	fmt.Fprintf(writer, "Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(resHdr))
+	writer.WriteString(resHdr)
+	if len(item.Data) > 0 {
+		fmt.Fprintf(writer, "%x\r\n", len(item.Data))
+		writer.Write(item.Data)
+		writer.WriteString("\r\n")
+	}
+	writer.WriteString("0\r\n\r\n")
+	if err = writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	status, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(status, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return nil, errors.New("ICAP响应格式有误: " + status)
+	}
+	switch fields[1] {
+	case "204":
+		return &Decision{Action: ActionAllow}, nil
+	case "200":
+		reason := "ICAP服务修改了内容"
+		for _, h := range icapViolationHeaders {
+			if v := header.Get(h); v != "" {
+				reason = h + ": " + v
+				break
+			}
+		}
+		return &Decision{Action: ActionBlock, Reason: reason}, nil
+	default:
+		return nil, errors.New("ICAP服务返回" + status)
+	}
+}
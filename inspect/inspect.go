@@ -0,0 +1,160 @@
+package inspect
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// 检查结果
+const (
+	ActionAllow  = "allow"  //放行
+	ActionBlock  = "block"  //拦截
+	ActionRedact = "redact" //替换敏感内容后放行
+)
+
+// 检查方向
+const (
+	DirectionRequest  = "request"  //InBound检查请求Body
+	DirectionResponse = "response" //OutBound检查响应Body
+)
+
+// defaultMaxSize 默认检查的内容长度上限
+const defaultMaxSize = 10 * 1024 * 1024
+
+// Item 待检查的内容
+type Item struct {
+	ReqID       string //请求标识
+	Direction   string //检查方向
+	ContentType string //内容类型
+	Data        []byte //内容
+}
+
+// Decision 检查结论
+type Decision struct {
+	Action string //allow、block、redact
+	Reason string //拦截或替换的原因
+	Data   []byte //redact时替换后的内容
+}
+
+// Inspector 内容检查器
+type Inspector interface {
+	Name() string
+	Inspect(item *Item) (*Decision, error)
+}
+
+// Blocked 内容被拦截
+type Blocked struct {
+	Inspector string //拦截的检查器
+	Reason    string //拦截原因
+}
+
+func (b *Blocked) Error() string {
+	return fmt.Sprintf("内容检查未通过(%s): %s", b.Inspector, b.Reason)
+}
+
+// Pipeline 按顺序执行的内容检查，前一个检查器替换后的内容交给后续检查器
+type Pipeline struct {
+	direction  string
+	maxSize    int64
+	inspectors []Inspector
+}
+
+// New 创建内容检查，InBound检查请求Body，OutBound检查响应Body，未启用时返回nil
+func New(inBound bool, cfg *config.Config) (*Pipeline, error) {
+	ic := cfg.Inspect
+	if ic == nil || len(ic.Inspectors) == 0 {
+		return nil, nil
+	}
+	direction := DirectionResponse
+	if inBound {
+		direction = DirectionRequest
+	}
+	if (inBound && !ic.Request) || (!inBound && !ic.Response) {
+		return nil, nil
+	}
+	pipeline := &Pipeline{
+		direction: direction,
+		maxSize:   ic.MaxSize,
+	}
+	if pipeline.maxSize <= 0 {
+		pipeline.maxSize = defaultMaxSize
+	}
+	for i, c := range ic.Inspectors {
+		var inspector Inspector
+		var err error
+		switch c.Type {
+		case "command":
+			inspector, err = newCommandInspector(c)
+		case "icap":
+			inspector, err = newICAPInspector(c)
+		case "regex":
+			inspector, err = newRegexInspector(c)
+		default:
+			err = fmt.Errorf("检查器类型%s不支持", c.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("inspectors[%d]配置有误: %v", i, err)
+		}
+		pipeline.inspectors = append(pipeline.inspectors, inspector)
+	}
+	return pipeline, nil
+}
+
+// Inspect 读取并检查内容，返回检查后(可能已替换)的内容，被拦截时返回*Blocked
+func (pipeline *Pipeline) Inspect(reqID string, header http.Header, body io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, pipeline.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > pipeline.maxSize {
+		blocked := &Blocked{Inspector: "maxSize", Reason: fmt.Sprintf("内容长度超出检查上限%d", pipeline.maxSize)}
+		pipeline.audit(reqID, blocked.Inspector, ActionBlock, blocked.Reason)
+		return nil, blocked
+	}
+	item := &Item{
+		ReqID:       reqID,
+		Direction:   pipeline.direction,
+		ContentType: header.Get("Content-Type"),
+		Data:        data,
+	}
+	for _, inspector := range pipeline.inspectors {
+		decision, err := inspector.Inspect(item)
+		if err != nil {
+			//检查出错时不放行
+			decision = &Decision{Action: ActionBlock, Reason: "检查出错: " + err.Error()}
+		}
+		switch decision.Action {
+		case ActionBlock:
+			pipeline.audit(reqID, inspector.Name(), ActionBlock, decision.Reason)
+			return nil, &Blocked{Inspector: inspector.Name(), Reason: decision.Reason}
+		case ActionRedact:
+			pipeline.audit(reqID, inspector.Name(), ActionRedact, decision.Reason)
+			item.Data = decision.Data
+		default:
+			log.Debugf("内容检查通过(%s): %s", inspector.Name(), reqID)
+		}
+	}
+	return item.Data, nil
+}
+
+// audit 记录拦截或替换的审计事件
+func (pipeline *Pipeline) audit(reqID string, inspector string, action string, reason string) {
+	log.WithFields(log.Fields{
+		"event":     "inspect",
+		"direction": pipeline.direction,
+		"inspector": inspector,
+		"action":    action,
+	}).Warn("内容检查", reqID, ": ", reason)
+}
+
+// NewBody 以检查后的内容创建Body
+func NewBody(data []byte) io.ReadCloser {
+	return ioutil.NopCloser(bytes.NewReader(data))
+}
@@ -0,0 +1,84 @@
+package inspect
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// regexRule 正则表达式规则
+type regexRule struct {
+	regex       *regexp.Regexp
+	action      string
+	replacement []byte
+}
+
+// regexInspector 按正则表达式或关键字检查内容
+type regexInspector struct {
+	name  string
+	rules []*regexRule
+}
+
+func newRegexInspector(cfg *config.InspectorConfig) (*regexInspector, error) {
+	inspector := &regexInspector{name: cfg.Name}
+	if inspector.name == "" {
+		inspector.name = "regex"
+	}
+	for _, rc := range cfg.Rules {
+		pattern := rc.Pattern
+		if rc.Keyword != "" {
+			pattern = regexp.QuoteMeta(rc.Keyword)
+		}
+		if pattern == "" {
+			return nil, errors.New("规则未配置pattern或keyword")
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		rule := &regexRule{
+			regex:       regex,
+			action:      rc.Action,
+			replacement: []byte(rc.Replacement),
+		}
+		switch rule.action {
+		case "":
+			rule.action = ActionBlock
+		case ActionBlock:
+		case ActionRedact:
+			if rc.Replacement == "" {
+				rule.replacement = []byte("***")
+			}
+		default:
+			return nil, fmt.Errorf("规则的处理方式%s不支持", rule.action)
+		}
+		inspector.rules = append(inspector.rules, rule)
+	}
+	return inspector, nil
+}
+
+func (inspector *regexInspector) Name() string {
+	return inspector.name
+}
+
+// Inspect 匹配拦截规则时拦截，匹配替换规则时替换匹配的内容
+func (inspector *regexInspector) Inspect(item *Item) (*Decision, error) {
+	data := item.Data
+	redacted := ""
+	for _, rule := range inspector.rules {
+		if !rule.regex.Match(data) {
+			continue
+		}
+		if rule.action == ActionBlock {
+			return &Decision{Action: ActionBlock, Reason: "匹配规则" + rule.regex.String()}, nil
+		}
+		data = rule.regex.ReplaceAll(data, rule.replacement)
+		redacted = "替换规则" + rule.regex.String() + "匹配的内容"
+	}
+	if redacted != "" {
+		return &Decision{Action: ActionRedact, Reason: redacted, Data: data}, nil
+	}
+	return &Decision{Action: ActionAllow}, nil
+}
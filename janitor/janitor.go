@@ -0,0 +1,115 @@
+package janitor
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// Janitor 定时清理传输目录下遗留的过期文件
+type Janitor struct {
+	path       string        //清理目录
+	interval   time.Duration //清理间隔
+	maxAge     time.Duration //文件最长保留时间，0表示不限制
+	maxCount   int           //目录文件数量上限，0表示不限制
+	maxSize    int64         //目录文件总大小上限(字节)，0表示不限制
+	quarantine string        //隔离目录，为空时直接删除文件
+}
+
+// New 创建目录清理器，未配置任何清理规则时返回nil
+func New(path string, cfg *config.Config) (*Janitor, error) {
+	if cfg.FileMaxAge <= 0 && cfg.FileMaxCount <= 0 && cfg.FileMaxSize <= 0 {
+		return nil, nil
+	}
+	if cfg.FileMaxAge > 0 && cfg.FileMaxAge <= cfg.Timeout {
+		return nil, errors.New("fileMaxAge必须大于timeout")
+	}
+	if cfg.FileCleanInterval <= 0 {
+		return nil, errors.New("fileCleanInterval必须大于0")
+	}
+	quarantine := cfg.FileQuarantine
+	if quarantine != "" {
+		quarantine = filepath.Join(quarantine, filepath.Base(path))
+		if err := os.MkdirAll(quarantine, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Janitor{
+		path:       path,
+		interval:   time.Duration(cfg.FileCleanInterval) * time.Millisecond,
+		maxAge:     time.Duration(cfg.FileMaxAge) * time.Millisecond,
+		maxCount:   cfg.FileMaxCount,
+		maxSize:    cfg.FileMaxSize,
+		quarantine: quarantine,
+	}, nil
+}
+
+// Start 启动定时清理
+func (janitor *Janitor) Start() {
+	log.Println("开始定时清理文件目录", janitor.path)
+	for {
+		time.Sleep(janitor.interval)
+		janitor.CleanUp()
+	}
+}
+
+// CleanUp 清理过期文件，并按修改时间从旧到新清理超出数量或大小上限的文件
+func (janitor *Janitor) CleanUp() {
+	infos, err := ioutil.ReadDir(janitor.path)
+	if err != nil {
+		log.Error("获取目录文件列表出错", janitor.path, err)
+		return
+	}
+	var files []os.FileInfo
+	var total int64
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	count := len(files)
+	for _, info := range files {
+		var reason string
+		switch {
+		case janitor.maxAge > 0 && time.Since(info.ModTime()) > janitor.maxAge:
+			reason = "文件过期"
+		case janitor.maxCount > 0 && count > janitor.maxCount:
+			reason = "超出目录文件数量上限"
+		case janitor.maxSize > 0 && total > janitor.maxSize:
+			reason = "超出目录文件大小上限"
+		default:
+			//文件按修改时间排序，其余文件无需清理
+			return
+		}
+		if err := janitor.remove(info.Name()); err != nil {
+			log.Error("清理文件", info.Name(), "出错", err)
+			continue
+		}
+		log.WithField("event", "janitor").Warnf("%v: %v, 大小:%v, 修改时间:%v",
+			reason, filepath.Join(janitor.path, info.Name()), info.Size(), info.ModTime().Format(time.RFC3339))
+		count--
+		total -= info.Size()
+	}
+}
+
+// remove 删除或隔离文件
+func (janitor *Janitor) remove(fileName string) error {
+	fullpath := filepath.Join(janitor.path, fileName)
+	if janitor.quarantine == "" {
+		return os.Remove(fullpath)
+	}
+	return os.Rename(fullpath, filepath.Join(janitor.quarantine, fileName))
+}
@@ -0,0 +1,176 @@
+package journal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// compactEvery 追加多少条记录后压缩一次日志文件
+const compactEvery = 10000
+
+// Journal 持久化记录已处理的请求标识，重启后仍可判断请求是否已处理。
+// 未启用时为nil，nil对象的方法均可安全调用
+type Journal struct {
+	lock      sync.Mutex
+	fileName  string               //日志文件
+	file      *os.File             //追加写入的日志文件
+	ids       map[string]time.Time //已记录的请求标识及记录时间
+	retention time.Duration        //记录保留时间
+	appended  int                  //上次压缩后追加的记录数
+}
+
+// Open 打开name对应的日志文件，未配置日志目录时返回nil
+func Open(name string, cfg *config.Config) (*Journal, error) {
+	if cfg.JournalDirectory == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.JournalDirectory, 0755); err != nil {
+		return nil, err
+	}
+	journal := &Journal{
+		fileName:  filepath.Join(cfg.JournalDirectory, name+".journal"),
+		ids:       make(map[string]time.Time),
+		retention: time.Duration(cfg.JournalRetention) * time.Millisecond,
+	}
+	if err := journal.load(); err != nil {
+		return nil, err
+	}
+	if err := journal.compact(); err != nil {
+		return nil, err
+	}
+	log.Printf("已加载处理记录%v条: %v", len(journal.ids), journal.fileName)
+	return journal, nil
+}
+
+// load 读取日志文件，每行记录为"毫秒时间戳 请求标识"
+func (journal *Journal) load() error {
+	file, err := os.Open(journal.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			//崩溃时可能遗留不完整的记录
+			continue
+		}
+		ms, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		journal.ids[fields[1]] = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	return scanner.Err()
+}
+
+// compact 清除过期记录，重写日志文件
+func (journal *Journal) compact() error {
+	if journal.file != nil {
+		journal.file.Close()
+		journal.file = nil
+	}
+	tmpName := journal.fileName + ".tmp"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for id, t := range journal.ids {
+		if time.Since(t) > journal.retention {
+			delete(journal.ids, id)
+			continue
+		}
+		fmt.Fprintf(w, "%d %s\n", t.UnixNano()/int64(time.Millisecond), id)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, journal.fileName)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	journal.appended = 0
+	journal.file, err = os.OpenFile(journal.fileName, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// append 追加一条记录
+func (journal *Journal) append(id string, sync bool) error {
+	now := time.Now()
+	journal.ids[id] = now
+	if _, err := fmt.Fprintf(journal.file, "%d %s\n", now.UnixNano()/int64(time.Millisecond), id); err != nil {
+		return err
+	}
+	if sync {
+		if err := journal.file.Sync(); err != nil {
+			return err
+		}
+	}
+	journal.appended++
+	if journal.appended >= compactEvery {
+		return journal.compact()
+	}
+	return nil
+}
+
+// Claim 记录请求标识并立即写入磁盘，请求已记录过时返回false
+func (journal *Journal) Claim(id string) bool {
+	if journal == nil {
+		return true
+	}
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	if _, ok := journal.ids[id]; ok {
+		return false
+	}
+	if err := journal.append(id, true); err != nil {
+		log.Error("写入处理记录出错", id, err)
+	}
+	return true
+}
+
+// Record 记录请求标识，不等待写入磁盘
+func (journal *Journal) Record(id string) {
+	if journal == nil {
+		return
+	}
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	if err := journal.append(id, false); err != nil {
+		log.Error("写入处理记录出错", id, err)
+	}
+}
+
+// Contains 请求标识是否已记录
+func (journal *Journal) Contains(id string) bool {
+	if journal == nil {
+		return false
+	}
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	_, ok := journal.ids[id]
+	return ok
+}
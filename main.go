@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -15,6 +17,7 @@ import (
 	"github.com/jamsa/hgap/config"
 	"github.com/jamsa/hgap/inbound"
 	"github.com/jamsa/hgap/outbound"
+	"github.com/jamsa/hgap/router"
 )
 
 var help = `
@@ -22,6 +25,8 @@ var help = `
   Commands:
     inbound - run as inbound server mode
     outbound - run as outbound server mode
+    routes test [-host host] [-method method] [-header "name: value"] <uri>
+      - show which route matches the request
 `
 
 func initLog(subcmd string, cfg *config.LogConfig) {
@@ -94,8 +99,77 @@ func main() {
 			log.Fatal("无法启动OutBound服务", err)
 		}
 		outb.Start()
+	case "routes":
+		if len(args) < 2 || args[0] != "test" {
+			fmt.Print(help)
+			os.Exit(1)
+		}
+		testRoute(cfg, args[1:])
 	default:
 		fmt.Print(help)
 		os.Exit(0)
 	}
 }
+
+// headerFlags 可重复指定的请求头参数
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// testRoute 显示与请求匹配的路由规则
+func testRoute(cfg *config.Config, args []string) {
+	var headers headerFlags
+	flags := flag.NewFlagSet("routes test", flag.ExitOnError)
+	host := flags.String("host", "", "request host")
+	method := flags.String("method", http.MethodGet, "request method")
+	flags.Var(&headers, "header", "request header, \"name: value\"")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Print(help)
+		os.Exit(1)
+	}
+	uri := flags.Arg(0)
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		log.Fatal("请求URI有误", err)
+	}
+	req := &http.Request{
+		Method:     strings.ToUpper(*method),
+		URL:        u,
+		RequestURI: uri,
+		Host:       *host,
+		Header:     make(http.Header),
+	}
+	for _, h := range headers {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			log.Fatal("请求头格式有误", h)
+		}
+		req.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	r, err := router.New(cfg)
+	if err != nil {
+		log.Fatal("路由配置有误", err)
+	}
+	fmt.Println("路由规则(按匹配顺序):")
+	for i, route := range r.Routes() {
+		fmt.Printf("  %d. %s\n", i+1, route)
+	}
+	route, _, target, err := r.Match(req)
+	if err != nil {
+		log.Fatal("重写URL出错", err)
+	}
+	if route == nil {
+		fmt.Printf("%s 无匹配的路由\n", uri)
+		os.Exit(1)
+	}
+	fmt.Printf("%s 匹配 %s\n  -->  %s\n", uri, route, target)
+}
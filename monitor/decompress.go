@@ -0,0 +1,39 @@
+package monitor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/jamsa/hgap/packet"
+)
+
+// decompressReader 读取消息头的压缩标志，并按标志解压后续的消息数据，首次读取时才读取消息头
+// 不是压缩标志时视为旧版本发送的无消息头的数据，原样返回(Http报文不会以压缩标志的取值开始)
+type decompressReader struct {
+	source io.Reader //解密后的数据
+	reader io.Reader //解压后的数据，读取消息头前为nil
+}
+
+// Read 读取数据
+func (dr *decompressReader) Read(p []byte) (int, error) {
+	if dr.reader == nil {
+		flag := make([]byte, 1)
+		if _, err := io.ReadFull(dr.source, flag); err != nil {
+			return 0, err
+		}
+		switch flag[0] {
+		case packet.CompressNone:
+			dr.reader = dr.source
+		case packet.CompressGzip:
+			zr, err := gzip.NewReader(dr.source)
+			if err != nil {
+				return 0, err
+			}
+			dr.reader = zr
+		default:
+			dr.reader = io.MultiReader(bytes.NewReader(flag), dr.source)
+		}
+	}
+	return dr.reader.Read(p)
+}
@@ -1,17 +1,24 @@
 package monitor
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"runtime"
-	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/janitor"
+	"github.com/jamsa/hgap/packet"
+	"github.com/jamsa/hgap/security"
 )
 
 // FileMonitor 文件系统监视
@@ -20,62 +27,92 @@ type FileMonitor struct {
 	*Monitor
 	path string //监视目录
 	//suffix        string           //文件后续
+	mode          string           //监视模式(notify:文件系统事件,poll:定时扫描)
 	scanInterval  int              //扫描频度(ms)
+	rescan        int              //notify模式下补充扫描的频度(ms)，0表示不扫描
 	timeout       int              //等侍文件就绪的超时时间(ms)
 	checkInterval int              //检查频度(ms)
 	fileExt       string           //文件扩展名
 	lastFiles     map[string]int64 //最后一次扫描的目录文件清单
 	keepFile      bool             //保留接收到的文件
+	manifest      bool             //根据清单文件校验数据文件
+	janitor       *janitor.Janitor //目录清理器，未配置清理规则时为nil
+	scanned       bool             //已完成启动后的首次扫描
+	stored        sync.Map         //启动时目录中已存在的文件，校验签名时不检查时间戳
 }
 
-// TODO 增加cleanUp定时清理目录下的垃圾文件
-
 // Start 启动监视
 func (monitor *FileMonitor) Start(onReady OnReady) {
 	monitor.onReady = onReady
+	if monitor.janitor != nil {
+		go monitor.janitor.Start()
+	}
+	if monitor.mode == "notify" {
+		err := monitor.notify()
+		log.Warn("文件系统事件监视不可用，改为定时扫描目录", err)
+	}
 	log.Println("开始监视文件目录", monitor.path)
 	for {
 		//start := time.Now()
-		path := monitor.path
-		lastFiles := monitor.lastFiles
-		newFiles := make(map[string]int64)
-		files, err := ioutil.ReadDir(path)
-		if err != nil {
-			log.Error("获取目录文件列表出错", path, err)
-			continue
-		}
-		for i := 0; i < len(files); i++ {
-			file := files[i]
-			fileSize := file.Size()
-			fileName := file.Name()
-			if !file.IsDir() && fileSize > 0 {
-				newFiles[fileName] = fileSize
-				_, ok := lastFiles[fileName]
-				if ok {
-
-				} else {
-					log.Println("新的文件", fileName)
-					go monitor.createHandler(fileName)
-				}
+		monitor.scan()
+		time.Sleep(time.Duration(monitor.scanInterval) * time.Millisecond)
+	}
+}
+
+// scan 扫描目录，处理新增的文件
+func (monitor *FileMonitor) scan() {
+	path := monitor.path
+	lastFiles := monitor.lastFiles
+	newFiles := make(map[string]int64)
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		log.Error("获取目录文件列表出错", path, err)
+		return
+	}
+	for i := 0; i < len(files); i++ {
+		file := files[i]
+		fileSize := file.Size()
+		fileName := file.Name()
+		if !file.IsDir() && fileSize > 0 && monitor.isDataFile(fileName) {
+			newFiles[fileName] = fileSize
+			_, ok := lastFiles[fileName]
+			if ok {
+
+			} else {
+				log.Println("新的文件", fileName)
+				go monitor.createHandler(fileName)
 			}
 		}
-		monitor.lastFiles = newFiles
-		time.Sleep(time.Duration(monitor.scanInterval) * time.Millisecond)
 	}
+	if !monitor.scanned {
+		//重启前遗留的文件可能已超出签名时间戳的允许范围
+		for fileName := range newFiles {
+			monitor.stored.Store(fileName, true)
+		}
+		monitor.scanned = true
+	}
+	monitor.lastFiles = newFiles
+}
+
+// isDataFile 是否为待处理的数据文件，忽略临时文件及清单文件
+func (monitor *FileMonitor) isDataFile(fileName string) bool {
+	return strings.HasSuffix(fileName, monitor.fileExt)
 }
 
 // Remove 删除数据
 func (monitor *FileMonitor) Remove(reqID string) {
 	if !monitor.keepFile {
 		log.Println("删除监控的文件" + reqID)
-		os.Remove(filepath.Join(monitor.path, reqID) + monitor.fileExt)
+		fileName := filepath.Join(monitor.path, reqID) + monitor.fileExt
+		os.Remove(fileName)
+		os.Remove(fileName + packet.ManifestExt)
 	}
 }
 
 // Read 读取数据
-func (monitor *FileMonitor) Read(reqID string) ([]byte, error) {
+func (monitor *FileMonitor) Read(reqID string) (io.ReadCloser, error) {
 	fileName := reqID + monitor.fileExt
-	return monitor.readFile(fileName)
+	return monitor.readFile(reqID, fileName)
 }
 
 // DebugTimeout 超时诊断
@@ -105,97 +142,97 @@ func (monitor *FileMonitor) createHandler(fileName string) {
 		os.Remove(filepath.Join(monitor.path, fileName))
 	}*/
 
-	reqID := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	reqID := strings.TrimSuffix(fileName, monitor.fileExt)
 	monitor.onReady(reqID) //, buf)
 }
 
 // 读取文件
-func (monitor *FileMonitor) readFile(fileName string) ([]byte, error) {
+func (monitor *FileMonitor) readFile(reqID string, fileName string) (io.ReadCloser, error) {
 	fullpath := filepath.Join(monitor.path, fileName)
-	content, err := ioutil.ReadFile(fullpath)
+	file, err := os.Open(fullpath)
 	if err != nil {
 		//log.Println("读取请求文件", fileName, "出错", err)
 		return nil, err
 	}
-	if monitor.textTransfer {
-		content, err = base64.StdEncoding.DecodeString(string(content))
+	reader := monitor.textReader(file)
+	if monitor.signer != nil {
+		//先完整校验签名，再从同一文件句柄读取数据，避免校验后文件被替换
+		_, stored := monitor.stored.LoadAndDelete(fileName)
+		size, err := monitor.verifyReader(fileName, reader, stored)
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			reader, err = security.SignedPayload(monitor.textReader(file), size)
+		}
 		if err != nil {
-			//log.Println("解码文件", fileName, "出错", err)
+			file.Close()
 			return nil, err
 		}
 	}
-	return content, nil
+	return readCloser{monitor.decoder(reqID, reader), file}, nil
+}
+
+// textReader 纯文本传输时对文件内容进行base64解码
+func (monitor *FileMonitor) textReader(file io.Reader) io.Reader {
+	if monitor.textTransfer {
+		return base64.NewDecoder(base64.StdEncoding, file)
+	}
+	return file
 }
 
-// 等侍文件就绪
+// 等侍文件就绪，发送端写入完成后才将文件重命名为正式文件名，未启用清单时无需等待
 func (monitor *FileMonitor) waitForFile(fileName string) error {
+	if !monitor.manifest {
+		return nil
+	}
 	start := time.Now()
-	fullpath := filepath.Join(monitor.path, fileName)
-	reqID := strings.TrimSuffix(fileName, filepath.Ext(fileName))
-	eof := "EOF" + reqID
-
 	for {
-		if time.Since(start) > time.Duration(monitor.timeout)*time.Millisecond {
-			return errors.New("文件处理超时")
-		}
-
-		result := checkFile(fullpath, eof)
-		if result {
+		err := monitor.checkManifest(fileName)
+		if err == nil {
 			return nil
 		}
+		if time.Since(start) > time.Duration(monitor.timeout)*time.Millisecond {
+			return fmt.Errorf("文件处理超时: %v", err)
+		}
+		log.Debug("文件尚未就绪", fileName, err)
 		time.Sleep(time.Duration(monitor.checkInterval) * time.Millisecond)
 	}
 }
 
-//检查文件完整性
-func checkFile(filename string, eof string) (result bool) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Error("checkFile未知错误", r)
-			debug.PrintStack()
-			result = false
-		}
-	}()
-	eoflen := len([]byte(eof))
-
-	if runtime.GOOS == "windows" {
-		if err := os.Chmod(filename, 0600); err != nil {
-			log.Error("checkFile文件无法改为0600", err)
-			return false
-		}
+// checkManifest 根据清单文件检查数据文件的大小及校验和，只读取文件不做修改
+func (monitor *FileMonitor) checkManifest(fileName string) error {
+	fullpath := filepath.Join(monitor.path, fileName)
+	data, err := ioutil.ReadFile(fullpath + packet.ManifestExt)
+	if err != nil {
+		return err
+	}
+	manifest, err := packet.DecodeManifest(data)
+	if err != nil {
+		return err
+	}
+	if manifest.ID != strings.TrimSuffix(fileName, monitor.fileExt) {
+		return errors.New("清单文件标识不匹配")
 	}
 
-	file, err := os.OpenFile(filename, os.O_RDWR, 0644)
+	file, err := os.Open(fullpath)
 	if err != nil {
-		log.Error("checkFile文件无法打开", err)
-		return false
+		return err
 	}
 	defer file.Close()
-
-	buf := make([]byte, eoflen)
-
-	stat, err := file.Stat() //os.Stat(filename)
+	stat, err := file.Stat()
 	if err != nil {
-		log.Error("checkFile无法获取文件信息", filename, nil)
-		return false
-	}
-	start := stat.Size() - int64(eoflen)
-	if start < 0 {
-		log.Error("checkFile文件大小不匹配", filename, start)
-		return false
-	}
-	_, err = file.ReadAt(buf, start)
-	if err == nil && string(buf) == eof {
-		log.Debug("checkFile文件结束内容匹配", filename, string(buf))
-		file.Seek(0, 0)
-		err = file.Truncate(start)
-		if err != nil {
-			log.Error("checkFile truncate file出错", err, start)
-		}
-		file.Sync()
-
-		return true
+		return err
+	}
+	if stat.Size() != manifest.Size {
+		return fmt.Errorf("文件大小与清单不符: %v/%v", stat.Size(), manifest.Size)
+	}
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != manifest.SHA256 {
+		return errors.New("文件校验和与清单不符")
 	}
-	log.Error("checkFile文件结束内容不匹配", err)
-	return false
+	return nil
 }
@@ -0,0 +1,75 @@
+package monitor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// notify 基于文件系统事件监视目录，监视无法启动或中断时返回错误，由调用方改为定时扫描
+func (monitor *FileMonitor) notify() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err = watcher.Add(monitor.path); err != nil {
+		return err
+	}
+	log.Println("开始监视文件目录事件", monitor.path)
+
+	//处理监视开始前已存在的文件
+	monitor.scan()
+	//网络共享目录中远程写入的文件可能不产生事件，定时补充扫描目录
+	var rescan <-chan time.Time
+	if monitor.rescan > 0 {
+		ticker := time.NewTicker(time.Duration(monitor.rescan) * time.Millisecond)
+		defer ticker.Stop()
+		rescan = ticker.C
+	}
+	for {
+		select {
+		case <-rescan:
+			monitor.scan()
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("文件系统事件通道已关闭")
+			}
+			monitor.fileEvent(ev)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("文件系统事件通道已关闭")
+			}
+			//事件可能已丢失(如队列溢出)，重新扫描目录
+			log.Warn("文件系统事件监视出错，重新扫描目录", err)
+			monitor.scan()
+		}
+	}
+}
+
+// fileEvent 处理文件系统事件
+func (monitor *FileMonitor) fileEvent(ev fsnotify.Event) {
+	fileName := filepath.Base(ev.Name)
+	if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		delete(monitor.lastFiles, fileName)
+		return
+	}
+	if ev.Op&(fsnotify.Create|fsnotify.Write) == 0 || !monitor.isDataFile(fileName) {
+		return
+	}
+	if _, ok := monitor.lastFiles[fileName]; ok {
+		return
+	}
+	//与定时扫描一致，文件写入数据后才处理
+	info, err := os.Stat(ev.Name)
+	if err != nil || info.IsDir() || info.Size() == 0 {
+		return
+	}
+	monitor.lastFiles[fileName] = info.Size()
+	log.Println("新的文件", fileName)
+	go monitor.createHandler(fileName)
+}
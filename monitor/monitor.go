@@ -2,15 +2,21 @@ package monitor
 
 import (
 	"errors"
+	"io"
 	"sync"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/jamsa/hgap/config"
+	"github.com/jamsa/hgap/janitor"
+	"github.com/jamsa/hgap/packet"
+	"github.com/jamsa/hgap/security"
 )
 
 // IMonitor 数据监听器
 type IMonitor interface {
 	Start(OnReady)
-	Read(string) ([]byte, error)
+	Read(string) (io.ReadCloser, error)
 	Remove(string)
 	DebugTimeout(string)
 	//SetOnReady(OnReady)
@@ -21,39 +27,113 @@ type OnReady func(string)
 
 // Monitor 数据监听器
 type Monitor struct {
-	textTransfer bool //纯文本传输(base64)
+	textTransfer bool             //纯文本传输(base64)
+	cipher       *security.Cipher //解密器，未启用加密时为nil
+	signer       *security.Signer //签名校验器，未启用签名时为nil
 	onReady      OnReady
 }
 
+// readCloser 组合Reader及Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// decoder 创建接收数据的解码(解密、解压)读取器
+func (monitor *Monitor) decoder(reqID string, r io.Reader) io.Reader {
+	if monitor.cipher != nil {
+		r = monitor.cipher.NewDecryptReader(reqID, r)
+	}
+	return &decompressReader{source: r}
+}
+
+// verify 校验数据包的签名，校验失败的数据将被丢弃并记录安全事件
+func (monitor *Monitor) verify(source string, data []byte) ([]byte, error) {
+	if monitor.signer == nil {
+		return data, nil
+	}
+	result, err := monitor.signer.Verify(data)
+	if err != nil {
+		log.WithField("event", "security").Warnf("丢弃来自%s的数据: %s", source, err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// verifyReader 校验文件数据流的签名，返回签名前的数据长度，校验失败时记录安全事件
+// stored为true时数据在启动前已保存，不检查时间戳
+func (monitor *Monitor) verifyReader(source string, r io.Reader, stored bool) (int64, error) {
+	verify := monitor.signer.VerifyReader
+	if stored {
+		verify = monitor.signer.VerifyStoredReader
+	}
+	size, err := verify(r)
+	if err != nil {
+		log.WithField("event", "security").Warnf("丢弃来自%s的数据: %s", source, err)
+		return 0, err
+	}
+	return size, nil
+}
+
 // NewMonitor 创建数据监听器
 func NewMonitor(inBound bool, cfg *config.Config) (IMonitor, error) {
 	var result IMonitor
+	cipher, err := security.LoadCipher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := security.LoadSigner(cfg)
+	if err != nil {
+		return nil, err
+	}
 	if inBound && cfg.OutTransferType == "file" {
+		fileJanitor, err := janitor.New(cfg.OutDirectory, cfg)
+		if err != nil {
+			return nil, err
+		}
 		fileMonitor := FileMonitor{
 			Monitor: &Monitor{
 				textTransfer: cfg.OutTextTransfer,
+				cipher:       cipher,
+				signer:       signer,
 			},
 			path:          cfg.OutDirectory,
+			mode:          cfg.MonitoringMode,
 			scanInterval:  cfg.FileScanInterval,
+			rescan:        cfg.FileRescanInterval,
 			timeout:       cfg.Timeout,
 			checkInterval: cfg.FileCheckInterval,
 			fileExt:       ".resp",
+			lastFiles:     make(map[string]int64),
 			keepFile:      cfg.KeepFiles,
+			manifest:      cfg.FileManifest,
+			janitor:       fileJanitor,
 		}
 		result = &fileMonitor
 		return result, nil
 	}
 	if !inBound && cfg.InTransferType == "file" {
+		fileJanitor, err := janitor.New(cfg.InDirectory, cfg)
+		if err != nil {
+			return nil, err
+		}
 		fileMonitor := FileMonitor{
 			Monitor: &Monitor{
 				textTransfer: cfg.InTextTransfer,
+				cipher:       cipher,
+				signer:       signer,
 			},
 			path:          cfg.InDirectory,
+			mode:          cfg.MonitoringMode,
 			scanInterval:  cfg.FileScanInterval,
+			rescan:        cfg.FileRescanInterval,
 			timeout:       cfg.Timeout,
 			checkInterval: cfg.FileCheckInterval,
 			fileExt:       ".req",
+			lastFiles:     make(map[string]int64),
 			keepFile:      cfg.KeepFiles,
+			manifest:      cfg.FileManifest,
+			janitor:       fileJanitor,
 		}
 		result = &fileMonitor
 		return result, nil
@@ -63,11 +143,17 @@ func NewMonitor(inBound bool, cfg *config.Config) (IMonitor, error) {
 			NetMonitor{
 				Monitor: &Monitor{
 					textTransfer: cfg.OutTextTransfer,
+					cipher:       cipher,
+					signer:       signer,
 				},
-				host:     cfg.InMonitorHost,
-				port:     cfg.InMonitorPort,
-				timeout:  cfg.Timeout,
-				contents: &sync.Map{},
+				host:      cfg.InMonitorHost,
+				port:      cfg.InMonitorPort,
+				timeout:   cfg.Timeout,
+				contents:  &sync.Map{},
+				removed:   &sync.Map{},
+				limits:    newNetLimits(cfg),
+				chunkSize: packet.ChunkSize("udp", cfg.OutChunkSize),
+				blockSize: fecBlockSize(cfg.OutFECDataShards, cfg.OutFECParityShards, packet.ChunkSize("udp", cfg.OutChunkSize)),
 			},
 		}
 		result = &fileMonitor
@@ -78,11 +164,17 @@ func NewMonitor(inBound bool, cfg *config.Config) (IMonitor, error) {
 			NetMonitor{
 				Monitor: &Monitor{
 					textTransfer: cfg.InTextTransfer,
+					cipher:       cipher,
+					signer:       signer,
 				},
-				host:     cfg.OutMonitorHost,
-				port:     cfg.OutMonitorPort,
-				timeout:  cfg.Timeout,
-				contents: &sync.Map{},
+				host:      cfg.OutMonitorHost,
+				port:      cfg.OutMonitorPort,
+				timeout:   cfg.Timeout,
+				contents:  &sync.Map{},
+				removed:   &sync.Map{},
+				limits:    newNetLimits(cfg),
+				chunkSize: packet.ChunkSize("udp", cfg.InChunkSize),
+				blockSize: fecBlockSize(cfg.InFECDataShards, cfg.InFECParityShards, packet.ChunkSize("udp", cfg.InChunkSize)),
 			},
 		}
 		result = &fileMonitor
@@ -90,33 +182,58 @@ func NewMonitor(inBound bool, cfg *config.Config) (IMonitor, error) {
 	}
 	if inBound && cfg.OutTransferType == "tcp" {
 		fileMonitor := TCPMonitor{
-			NetMonitor{
+			NetMonitor: NetMonitor{
 				Monitor: &Monitor{
 					textTransfer: cfg.OutTextTransfer,
+					cipher:       cipher,
+					signer:       signer,
 				},
-				host:     cfg.InMonitorHost,
-				port:     cfg.InMonitorPort,
-				timeout:  cfg.Timeout,
-				contents: &sync.Map{},
+				host:      cfg.InMonitorHost,
+				port:      cfg.InMonitorPort,
+				timeout:   cfg.Timeout,
+				contents:  &sync.Map{},
+				removed:   &sync.Map{},
+				limits:    newNetLimits(cfg),
+				chunkSize: packet.ChunkSize("tcp", cfg.OutChunkSize),
 			},
+			heartbeat: cfg.TCPHeartbeatInterval,
 		}
 		result = &fileMonitor
 		return result, nil
 	}
 	if !inBound && cfg.InTransferType == "tcp" {
 		fileMonitor := TCPMonitor{
-			NetMonitor{
+			NetMonitor: NetMonitor{
 				Monitor: &Monitor{
 					textTransfer: cfg.InTextTransfer,
+					cipher:       cipher,
+					signer:       signer,
 				},
-				host:     cfg.OutMonitorHost,
-				port:     cfg.OutMonitorPort,
-				timeout:  cfg.Timeout,
-				contents: &sync.Map{},
+				host:      cfg.OutMonitorHost,
+				port:      cfg.OutMonitorPort,
+				timeout:   cfg.Timeout,
+				contents:  &sync.Map{},
+				removed:   &sync.Map{},
+				limits:    newNetLimits(cfg),
+				chunkSize: packet.ChunkSize("tcp", cfg.InChunkSize),
 			},
+			heartbeat: cfg.TCPHeartbeatInterval,
 		}
 		result = &fileMonitor
 		return result, nil
 	}
 	return nil, errors.New("无法创建Monitor")
 }
+
+// fecBlockSize FEC分块长度，未启用FEC时为0
+func fecBlockSize(data int, parity int, chunkSize int) int {
+	if parity <= 0 {
+		return 0
+	}
+	return data * chunkSize
+}
+
+// maxPacketSize 接收的分组编码及签名后的最大长度，接收端同时支持各版本的分组
+func maxPacketSize(chunkSize int) int {
+	return chunkSize + packet.Overhead(packet.VersionLegacy) + security.SignOverhead
+}
@@ -0,0 +1,71 @@
+package monitor
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/fec"
+	"github.com/jamsa/hgap/packet"
+)
+
+// recoverBlock 使用FEC校验分组恢复分块中丢失的数据分组，调用方需持有c.lock
+func (monitor *NetMonitor) recoverBlock(c *NetContent, begin int) {
+	parities := c.parities[begin]
+	first := parities[0]
+	size := first.Size
+	count := first.Shards
+
+	shards := make([][]byte, count+fec.MaxShards)
+	missing := 0
+	for i := 0; i < count; i++ {
+		pack, ok := c.index[begin+i*size]
+		if !ok {
+			missing++
+			continue
+		}
+		shards[i] = make([]byte, size)
+		copy(shards[i], pack.Data)
+	}
+	if missing == 0 {
+		delete(c.parities, begin)
+		return
+	}
+	if missing > len(parities) {
+		return
+	}
+	for _, parity := range parities {
+		if parity.Parity <= fec.MaxShards && len(parity.Data) == size {
+			shards[count+parity.Parity-1] = parity.Data
+		}
+	}
+
+	if err := fec.Reconstruct(shards, count); err != nil {
+		log.Error("FEC恢复数据分组出错", c.id, err)
+		return
+	}
+	for i := 0; i < count; i++ {
+		offset := begin + i*size
+		if _, ok := c.index[offset]; ok {
+			continue
+		}
+		//仅最后一个分块的校验分组携带总长
+		end := offset + size
+		length := -1
+		if first.Length >= 0 && end >= first.Length {
+			end = first.Length
+			length = first.Length
+		}
+		if end <= offset {
+			continue
+		}
+		c.addPacket(&packet.Packet{
+			ID:     c.id,
+			Length: length,
+			Begin:  offset,
+			Size:   end - offset,
+			Data:   shards[i][:end-offset],
+		})
+	}
+	delete(c.parities, begin)
+	c.recovered += missing
+	log.Printf("通过FEC恢复%s的%d个分组，累计恢复%d个", c.id, missing, c.recovered)
+}
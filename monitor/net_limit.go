@@ -0,0 +1,68 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jamsa/hgap/config"
+	"github.com/jamsa/hgap/packet"
+)
+
+// 超出接收限制时拒绝接收消息的错误
+var (
+	ErrMessageTooLarge = errors.New("消息长度超出上限")
+	ErrOverloaded      = errors.New("接收缓存已满")
+)
+
+// netLimits 网络数据的接收限制，由同一NetMonitor接收的所有消息共享，为0的限制项不做限制
+type netLimits struct {
+	maxBuffered int64 //缓存的未读取数据总长上限
+	maxInFlight int32 //同时接收的消息数量上限
+	maxMessage  int64 //单个消息长度上限
+	buffered    int64 //缓存的未读取数据总长
+	inFlight    int32 //正在接收的消息数量
+}
+
+// newNetLimits 创建接收限制
+func newNetLimits(cfg *config.Config) *netLimits {
+	return &netLimits{
+		maxBuffered: cfg.MaxBufferedBytes,
+		maxInFlight: int32(cfg.MaxInFlight),
+		maxMessage:  cfg.MaxMessageSize,
+	}
+}
+
+// admit 开始接收新的消息，超出同时接收的消息数量上限时返回错误
+func (limits *netLimits) admit() error {
+	n := atomic.AddInt32(&limits.inFlight, 1)
+	if limits.maxInFlight > 0 && n > limits.maxInFlight {
+		atomic.AddInt32(&limits.inFlight, -1)
+		return fmt.Errorf("%w: 正在接收的消息数量达到上限%d", ErrOverloaded, limits.maxInFlight)
+	}
+	return nil
+}
+
+// leave 消息接收结束
+func (limits *netLimits) leave() {
+	atomic.AddInt32(&limits.inFlight, -1)
+}
+
+// check 检查分组是否超出消息长度及缓存上限
+func (limits *netLimits) check(pack *packet.Packet) error {
+	if limits.maxMessage > 0 && (int64(pack.Length) > limits.maxMessage || int64(pack.Begin+pack.Size) > limits.maxMessage) {
+		return fmt.Errorf("%w: %d", ErrMessageTooLarge, limits.maxMessage)
+	}
+	if pack.Parity > 0 {
+		return nil
+	}
+	if limits.maxBuffered > 0 && atomic.LoadInt64(&limits.buffered)+int64(pack.Size) > limits.maxBuffered {
+		return fmt.Errorf("%w: 缓存数据达到上限%d", ErrOverloaded, limits.maxBuffered)
+	}
+	return nil
+}
+
+// buffer 调整缓存的数据总长
+func (limits *netLimits) buffer(delta int) {
+	atomic.AddInt64(&limits.buffered, int64(delta))
+}
@@ -1,8 +1,9 @@
 package monitor
 
 import (
-	"bytes"
 	"errors"
+	"io"
+	"io/ioutil"
 	"sort"
 	"sync"
 	"time"
@@ -16,30 +17,213 @@ import (
 type NetContent struct {
 	id         string
 	lock       sync.Mutex
-	length     int //已接收长度
-	createTime time.Time
-	packets    []*packet.Packet
+	cond       *sync.Cond               //数据到达或内容被删除时通知读取方
+	length     int                      //已接收长度
+	total      int                      //总长，未收到最后一个分组时为-1
+	next       int                      //下一个待读取的位置
+	started    bool                     //已收到第一个分组并发出通知
+	removed    bool                     //已删除
+	createTime time.Time                //
+	updateTime time.Time                //最后一次接收数据的时间
+	received   rangeSet                 //已接收的数据区间
+	index      map[int]*packet.Packet   //按开始位置索引的数据分组
+	parities   map[int][]*packet.Packet //按分块开始位置索引的FEC校验分组
+	recovered  int                      //通过FEC恢复的分组数
+	limits     *netLimits               //接收限制
+	buffered   int                      //缓存的未读取数据长度
+	admitted   bool                     //占用同时接收的消息数量
+	rejected   error                    //超出接收限制被拒绝的原因
+}
+
+// addPacket 添加数据分组并通知读取方，分组中已接收过的部分将被忽略，有新数据时返回true，调用方需持有lock
+func (c *NetContent) addPacket(pack *packet.Packet) bool {
+	end := pack.Begin + pack.Size
+	if pack.Begin < 0 || pack.Size != len(pack.Data) {
+		log.Warnf("分组数据无效:%s,%d,%d", c.id, pack.Begin, pack.Size)
+		return false
+	}
+	if pack.Length >= 0 && pack.Length != c.total {
+		if c.total >= 0 || pack.Length < c.received.end() {
+			log.Warnf("分组总长与已接收数据不符:%s,%d/%d", c.id, pack.Length, c.total)
+			return false
+		}
+		c.total = pack.Length
+		c.cond.Broadcast()
+	}
+	if c.total >= 0 && end > c.total {
+		log.Warnf("分组超出总长:%s,%d-%d/%d", c.id, pack.Begin, end, c.total)
+		return false
+	}
+
+	gaps := c.received.add(pack.Begin, end)
+	for _, gap := range gaps {
+		piece := pack
+		if gap.begin != pack.Begin || gap.end != end {
+			//与已接收的分组部分重叠，只保留未接收的部分
+			piece = &packet.Packet{
+				ID:     pack.ID,
+				Length: pack.Length,
+				Begin:  gap.begin,
+				Size:   gap.end - gap.begin,
+				Data:   pack.Data[gap.begin-pack.Begin : gap.end-pack.Begin],
+			}
+		}
+		c.index[piece.Begin] = piece
+		c.length += piece.Size
+		c.buffer(piece.Size)
+	}
+	if len(gaps) == 0 {
+		return false
+	}
+	c.cond.Broadcast()
+	return true
+}
+
+// buffer 调整缓存的数据长度
+func (c *NetContent) buffer(delta int) {
+	c.buffered += delta
+	c.limits.buffer(delta)
+}
+
+// drop 释放缓存的数据分组，调用方需持有lock
+func (c *NetContent) drop(begin int) {
+	if pack, ok := c.index[begin]; ok {
+		c.buffer(-pack.Size)
+		delete(c.index, begin)
+	}
+}
+
+// discard 释放全部缓存的分组及接收名额，调用方需持有lock
+func (c *NetContent) discard() {
+	c.buffer(-c.buffered)
+	c.index = make(map[int]*packet.Packet)
+	c.parities = make(map[int][]*packet.Packet)
+	if c.admitted {
+		c.admitted = false
+		c.limits.leave()
+	}
+}
+
+// reject 拒绝接收消息，丢弃已缓存的数据及后续分组，调用方需持有lock
+func (c *NetContent) reject(err error) {
+	log.Warnf("拒绝接收%s: %v", c.id, err)
+	c.rejected = err
+	c.discard()
+	c.cond.Broadcast()
+}
+
+// ready 是否已收到起始数据，可以开始读取
+func (c *NetContent) ready() bool {
+	return c.total == 0 || (len(c.received) > 0 && c.received[0].begin == 0)
+}
+
+// contentReader 按顺序读取已接收的数据分组，数据未到达时等待
+type contentReader struct {
+	content   *NetContent
+	blockSize int    //FEC分块长度，当前分块已读取的分组需保留至分块读取完毕，用于恢复丢失的分组
+	consumed  []int  //当前分块已读取的分组位置
+	data      []byte //当前分组未读取的数据
+}
+
+// Read 读取数据
+func (reader *contentReader) Read(p []byte) (int, error) {
+	c := reader.content
+	for len(reader.data) == 0 {
+		c.lock.Lock()
+		for {
+			if c.rejected != nil {
+				c.lock.Unlock()
+				return 0, c.rejected
+			}
+			if c.removed {
+				c.lock.Unlock()
+				return 0, errors.New("数据已删除或接收超时" + c.id)
+			}
+			if c.total >= 0 && c.next >= c.total {
+				c.lock.Unlock()
+				if c.next != c.total || !c.received.contiguous(c.total) {
+					return 0, errors.New("接收的数据不连续" + c.id)
+				}
+				return 0, io.EOF
+			}
+			if pack, ok := c.index[c.next]; ok {
+				reader.data = pack.Data
+				c.next += pack.Size
+				reader.release(pack.Begin)
+				break
+			}
+			c.cond.Wait()
+		}
+		c.lock.Unlock()
+	}
+	n := copy(p, reader.data)
+	reader.data = reader.data[n:]
+	return n, nil
+}
+
+// release 释放已读取的分组，调用方需持有lock
+func (reader *contentReader) release(begin int) {
+	c := reader.content
+	if reader.blockSize <= 0 {
+		c.drop(begin)
+		return
+	}
+	reader.consumed = append(reader.consumed, begin)
+	if c.next/reader.blockSize != begin/reader.blockSize {
+		for _, v := range reader.consumed {
+			c.drop(v)
+		}
+		reader.consumed = reader.consumed[:0]
+	}
 }
 
 // NetMonitor 网络数据包监视
 type NetMonitor struct {
 	IMonitor
 	*Monitor
-	host     string    //监听主机
-	port     int       //监听端口
-	contents *sync.Map //数据
-	timeout  int       //等侍文件就绪的超时时间(ms)
+	host      string     //监听主机
+	port      int        //监听端口
+	contents  *sync.Map  //数据
+	removed   *sync.Map  //已删除数据的标识及删除时间，用于忽略删除后迟到的分组
+	timeout   int        //等侍文件就绪的超时时间(ms)
+	chunkSize int        //发送端的分组数据长度
+	blockSize int        //FEC分块长度，未启用FEC时为0
+	limits    *netLimits //接收限制
 }
 
 // Remove 删除数据
 func (monitor *NetMonitor) Remove(reqID string) {
 	log.Println("删除接收的数据", reqID)
-	monitor.contents.Delete(reqID)
+	monitor.removed.Store(reqID, time.Now())
+	content, ok := monitor.contents.LoadAndDelete(reqID)
+	if ok {
+		c := content.(*UDPContent)
+		c.lock.Lock()
+		c.removed = true
+		c.discard()
+		c.cond.Broadcast()
+		c.lock.Unlock()
+	}
 }
 
 // Read 读取数据
-func (monitor *NetMonitor) Read(reqID string) ([]byte, error) {
-	return monitor.readAll(reqID)
+func (monitor *NetMonitor) Read(reqID string) (io.ReadCloser, error) {
+	content, ok := monitor.contents.Load(reqID)
+	if !ok {
+		return nil, errors.New("找不到请求数据" + reqID)
+	}
+	c := content.(*UDPContent)
+	c.lock.Lock()
+	err := c.rejected
+	c.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	reader := &contentReader{
+		content:   &content.(*UDPContent).NetContent,
+		blockSize: monitor.blockSize,
+	}
+	return ioutil.NopCloser(monitor.decoder(reqID, reader)), nil
 }
 
 // DebugTimeout 超时诊断
@@ -48,14 +232,18 @@ func (monitor *NetMonitor) DebugTimeout(reqID string) {
 	content, ok := monitor.contents.Load(reqID)
 	if ok {
 		c := content.(*UDPContent)
-		sort.Slice(c.packets, func(i, j int) bool {
-			left := c.packets[i]
-			right := c.packets[j]
-			return left.Begin < right.Begin
+		c.lock.Lock()
+		var packets []*packet.Packet
+		for _, v := range c.index {
+			packets = append(packets, v)
+		}
+		log.Debugf("已读取至%d/%d，已接收%d字节，未读取%d个数据包", c.next, c.total, c.length, len(packets))
+		c.lock.Unlock()
+		sort.Slice(packets, func(i, j int) bool {
+			return packets[i].Begin < packets[j].Begin
 		})
-		log.Debug("接收到", len(c.packets), "个数据包")
 
-		for _, v := range c.packets {
+		for _, v := range packets {
 			log.Debugf("数据包信息:%s,%d/%d,%d", v.ID, v.Begin, v.Length, v.Size)
 		}
 
@@ -74,10 +262,12 @@ func (monitor *NetMonitor) cleanUp() {
 		var timeoutIDs []string
 		monitor.contents.Range(func(k, v interface{}) bool {
 			c := v.(*UDPContent)
-			if time.Now().Sub(c.createTime) >
+			c.lock.Lock()
+			if time.Now().Sub(c.updateTime) >
 				time.Duration(monitor.timeout)*time.Millisecond {
 				timeoutIDs = append(timeoutIDs, c.id)
 			}
+			c.lock.Unlock()
 			return true
 		})
 
@@ -86,6 +276,14 @@ func (monitor *NetMonitor) cleanUp() {
 			monitor.DebugTimeout(v)
 			monitor.Remove(v)
 		}
+
+		//已删除的标识保留两个超时周期，之后迟到的分组将被视为新数据
+		monitor.removed.Range(func(k, v interface{}) bool {
+			if time.Since(v.(time.Time)) > 2*time.Duration(monitor.timeout)*time.Millisecond {
+				monitor.removed.Delete(k)
+			}
+			return true
+		})
 	}
 }
 
@@ -97,45 +295,78 @@ func (monitor *NetMonitor) packetReceive(pack *packet.Packet) {
 		}
 	}()
 	log.Debug("接收到", pack.ID, "的分包")
+	if _, ok := monitor.removed.Load(pack.ID); ok {
+		log.Debug("忽略已删除数据的分包", pack.ID, pack.Begin)
+		return
+	}
 	content, ok := monitor.contents.Load(pack.ID)
 	if !ok {
-		content = &UDPContent{
+		now := time.Now()
+		c := &UDPContent{
 			NetContent{
 				id:         pack.ID,
 				length:     0,
-				createTime: time.Now(),
+				total:      -1,
+				createTime: now,
+				updateTime: now,
+				index:      make(map[int]*packet.Packet),
+				parities:   make(map[int][]*packet.Packet),
+				limits:     monitor.limits,
 			},
 		}
-		monitor.contents.Store(pack.ID, content)
+		c.cond = sync.NewCond(&c.lock)
+		//发布前确定是否接收，超出同时接收的消息数量上限时仍需通知读取方
+		err := monitor.limits.admit()
+		c.admitted = err == nil
+		c.rejected = err
+		var loaded bool
+		content, loaded = monitor.contents.LoadOrStore(pack.ID, c)
+		if loaded && err == nil {
+			monitor.limits.leave()
+		}
+		if !loaded && err != nil {
+			log.Warnf("拒绝接收%s: %v", pack.ID, err)
+		}
 	}
 	c := content.(*UDPContent)
 	c.lock.Lock()
-	c.length += pack.Size
-	c.packets = append(c.packets, pack)
-	c.lock.Unlock()
-
-	//接收完毕
-	if c.length >= pack.Length {
-		monitor.onReady(pack.ID)
+	c.updateTime = time.Now()
+	if c.rejected == nil {
+		if err := monitor.limits.check(pack); err != nil {
+			c.reject(err)
+		}
 	}
-}
-
-// 读取完整内容
-func (monitor *NetMonitor) readAll(reqID string) ([]byte, error) {
-	content, ok := monitor.contents.Load(reqID)
-	var result bytes.Buffer
-	if ok {
-		c := content.(*UDPContent)
-		sort.Slice(c.packets, func(i, j int) bool {
-			left := c.packets[i]
-			right := c.packets[j]
-			return left.Begin < right.Begin
-		})
-		for _, v := range c.packets {
-			//log.Println("收集数据:", v.ID, v.Begin, "/", v.Length, v.Size)
-			result.Write(v.Data)
+	if c.rejected != nil {
+		//已拒绝的消息丢弃后续分组
+	} else if pack.Parity > 0 {
+		//已读取完毕的分块不再需要校验分组
+		if pack.Begin+pack.Shards*pack.Size > c.next {
+			c.parities[pack.Begin] = append(c.parities[pack.Begin], pack)
+			monitor.recoverBlock(&c.NetContent, pack.Begin)
+		}
+	} else if c.addPacket(pack) {
+		for begin, parities := range c.parities {
+			if pack.Begin >= begin && pack.Begin < begin+parities[0].Shards*parities[0].Size {
+				monitor.recoverBlock(&c.NetContent, begin)
+			}
 		}
-		return result.Bytes(), nil
 	}
-	return nil, errors.New("找不到请求数据" + reqID)
+	//started保证每个标识只通知一次
+	ready := (c.ready() || c.rejected != nil) && !c.started
+	if ready {
+		c.started = true
+	}
+	c.lock.Unlock()
+
+	//收到第一个分组或拒绝接收后即通知读取方，后续数据以流的方式读取，通知需异步执行以免阻塞后续分组的接收
+	if ready {
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error("处理数据", pack.ID, "的就绪通知出错", r)
+				}
+			}()
+			monitor.onReady(pack.ID)
+		}()
+	}
 }
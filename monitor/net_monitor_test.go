@@ -0,0 +1,233 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jamsa/hgap/fec"
+	"github.com/jamsa/hgap/packet"
+)
+
+const testID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+// newTestContent 与packetReceive相同的方式创建接收内容
+func newTestContent() *NetContent {
+	c := &NetContent{
+		id:       testID,
+		total:    -1,
+		index:    make(map[int]*packet.Packet),
+		parities: make(map[int][]*packet.Packet),
+		limits:   &netLimits{},
+	}
+	c.cond = sync.NewCond(&c.lock)
+	return c
+}
+
+// pack 创建body中[begin,end)的数据分组，length为-1时不携带总长
+func pack(body string, begin int, end int, length int) *packet.Packet {
+	return &packet.Packet{
+		ID:     testID,
+		Length: length,
+		Begin:  begin,
+		Size:   end - begin,
+		Data:   []byte(body[begin:end]),
+	}
+}
+
+// readContent 读取已接收完毕的内容
+func readContent(t *testing.T, c *NetContent, blockSize int) string {
+	data, err := ioutil.ReadAll(&contentReader{content: c, blockSize: blockSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAddPacket(t *testing.T) {
+	const body = "0123456789"
+	type step struct {
+		pack  *packet.Packet
+		added bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"顺序", []step{
+			{pack(body, 0, 4, -1), true},
+			{pack(body, 4, 8, -1), true},
+			{pack(body, 8, 10, 10), true},
+		}},
+		{"重复", []step{
+			{pack(body, 0, 4, -1), true},
+			{pack(body, 0, 4, -1), false},
+			{pack(body, 4, 8, -1), true},
+			{pack(body, 4, 8, -1), false},
+			{pack(body, 8, 10, 10), true},
+			{pack(body, 8, 10, 10), false},
+		}},
+		{"乱序", []step{
+			{pack(body, 8, 10, 10), true},
+			{pack(body, 0, 4, -1), true},
+			{pack(body, 4, 8, -1), true},
+		}},
+		{"重叠", []step{
+			{pack(body, 0, 6, -1), true},
+			{pack(body, 4, 10, 10), true},
+			{pack(body, 2, 8, -1), false},
+		}},
+		{"重叠且乱序", []step{
+			{pack(body, 6, 10, 10), true},
+			{pack(body, 0, 3, -1), true},
+			{pack(body, 2, 7, -1), true},
+		}},
+		{"超出总长", []step{
+			{pack(body, 0, 8, 8), true},
+			{pack(body, 0, 10, -1), false},
+			{pack(body, 8, 10, 10), false},
+		}},
+		{"总长与已接收数据不符", []step{
+			{pack(body, 0, 8, -1), true},
+			{pack(body, 0, 4, 4), false},
+			{pack(body, 8, 10, 10), true},
+		}},
+		{"数据长度无效", []step{
+			{&packet.Packet{ID: testID, Length: -1, Begin: 0, Size: 5, Data: []byte("012")}, false},
+			{&packet.Packet{ID: testID, Length: -1, Begin: -1, Size: 1, Data: []byte("0")}, false},
+			{pack(body, 0, 10, 10), true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContent()
+			for i, s := range tt.steps {
+				if added := c.addPacket(s.pack); added != s.added {
+					t.Fatalf("第%d个分组[%d,%d)返回%v, 期望%v", i, s.pack.Begin, s.pack.Begin+s.pack.Size, added, s.added)
+				}
+			}
+			total := c.total
+			if c.length != total || !c.received.contiguous(total) {
+				t.Fatalf("已接收%d字节%v, 期望连续的%d字节", c.length, c.received, total)
+			}
+			if c.buffered != total {
+				t.Errorf("缓存%d字节, 期望%d", c.buffered, total)
+			}
+			if got := readContent(t, c, 0); got != body[:total] {
+				t.Errorf("读取%q, 期望%q", got, body[:total])
+			}
+			if c.buffered != 0 || len(c.index) != 0 {
+				t.Errorf("读取完毕后仍缓存%d字节, %d个分组", c.buffered, len(c.index))
+			}
+		})
+	}
+}
+
+func TestAddPacketIncomplete(t *testing.T) {
+	const body = "0123456789"
+	c := newTestContent()
+	c.addPacket(pack(body, 0, 4, -1))
+	c.addPacket(pack(body, 8, 10, 10))
+	c.addPacket(pack(body, 0, 4, -1))
+	if c.length != 6 {
+		t.Errorf("已接收%d字节, 期望6", c.length)
+	}
+	if c.received.contiguous(c.total) {
+		t.Errorf("缺少分组时%v不应连续", c.received)
+	}
+}
+
+func TestRecoverBlock(t *testing.T) {
+	const size = 4
+	const body = "0123456789abcdefghij-xyz"
+	const count = 3
+	monitor := &NetMonitor{}
+	for lost := 0; lost < 6; lost++ {
+		c := newTestContent()
+		//两个分块，第二个分块的最后一个分组不足size
+		var blocks [][]*packet.Packet
+		for begin := 0; begin < len(body); begin += size * count {
+			var block []*packet.Packet
+			for i := 0; i < count && begin+i*size < len(body)-2; i++ {
+				end, length := begin+(i+1)*size, -1
+				if end >= len(body)-2 {
+					end, length = len(body)-2, len(body)-2
+				}
+				block = append(block, pack(body, begin+i*size, end, length))
+			}
+			blocks = append(blocks, block)
+		}
+		for i, block := range blocks {
+			shards := make([][]byte, len(block))
+			for j, p := range block {
+				shards[j] = make([]byte, size)
+				copy(shards[j], p.Data)
+				if i*count+j != lost {
+					c.addPacket(p)
+				}
+			}
+			parities, err := fec.Encode(shards, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := block[len(block)-1]
+			c.parities[block[0].Begin] = append(c.parities[block[0].Begin], &packet.Packet{
+				ID: testID, Length: last.Length, Begin: block[0].Begin, Size: size,
+				Data: parities[0], Parity: 1, Shards: len(block),
+			})
+			monitor.recoverBlock(c, block[0].Begin)
+		}
+		if got := readContent(t, c, size*count); got != body[:len(body)-2] {
+			t.Errorf("丢失第%d个分组时读取%q, 期望%q", lost, got, body[:len(body)-2])
+		}
+		if c.recovered != 1 {
+			t.Errorf("丢失第%d个分组时恢复%d个分组, 期望1", lost, c.recovered)
+		}
+	}
+}
+
+func TestPacketReceiveReadyOnce(t *testing.T) {
+	const body = "0123456789"
+	var notified int32
+	done := make(chan string, 10)
+	monitor := &NetMonitor{
+		Monitor: &Monitor{onReady: func(id string) {
+			atomic.AddInt32(&notified, 1)
+			done <- id
+		}},
+		contents: &sync.Map{},
+		removed:  &sync.Map{},
+		limits:   &netLimits{},
+	}
+	packets := []*packet.Packet{
+		pack(body, 4, 8, -1),
+		pack(body, 0, 4, -1),
+		pack(body, 0, 4, -1),
+		pack(body, 8, 10, 10),
+		pack(body, 0, 10, 10),
+		pack(body, 8, 10, 10),
+	}
+	for _, p := range packets {
+		monitor.packetReceive(p)
+	}
+	select {
+	case id := <-done:
+		if id != testID {
+			t.Errorf("通知%s, 期望%s", id, testID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到就绪通知")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&notified); n != 1 {
+		t.Errorf("就绪通知%d次, 期望1次", n)
+	}
+
+	content, _ := monitor.contents.Load(testID)
+	c := &content.(*UDPContent).NetContent
+	if got := readContent(t, c, 0); got != body {
+		t.Errorf("读取%q, 期望%q", got, body)
+	}
+}
@@ -0,0 +1,71 @@
+package monitor
+
+import (
+	"sort"
+)
+
+// span 数据区间[begin,end)
+type span struct {
+	begin int
+	end   int
+}
+
+// rangeSet 已接收的数据区间集合，区间按开始位置排序，互不重叠且不相邻
+type rangeSet []span
+
+// add 添加区间[begin,end)，返回其中此前未接收的部分
+func (set *rangeSet) add(begin int, end int) []span {
+	if begin >= end {
+		return nil
+	}
+	s := *set
+	//与新区间重叠或相邻的区间为s[i:k]
+	i := sort.Search(len(s), func(i int) bool { return s[i].end >= begin })
+	k := sort.Search(len(s), func(k int) bool { return s[k].begin > end })
+
+	var gaps []span
+	pos := begin
+	for j := i; j < k; j++ {
+		if s[j].begin > pos {
+			gaps = append(gaps, span{pos, s[j].begin})
+		}
+		if s[j].end > pos {
+			pos = s[j].end
+		}
+	}
+	if pos < end {
+		gaps = append(gaps, span{pos, end})
+	}
+
+	merged := span{begin, end}
+	if i < k {
+		if s[i].begin < merged.begin {
+			merged.begin = s[i].begin
+		}
+		if s[k-1].end > merged.end {
+			merged.end = s[k-1].end
+		}
+	}
+	result := make(rangeSet, 0, len(s)-(k-i)+1)
+	result = append(result, s[:i]...)
+	result = append(result, merged)
+	result = append(result, s[k:]...)
+	*set = result
+	return gaps
+}
+
+// end 已接收数据的最大结束位置
+func (set rangeSet) end() int {
+	if len(set) == 0 {
+		return 0
+	}
+	return set[len(set)-1].end
+}
+
+// contiguous 是否已接收从0开始的length长度的连续数据
+func (set rangeSet) contiguous(length int) bool {
+	if length == 0 {
+		return true
+	}
+	return len(set) == 1 && set[0].begin == 0 && set[0].end == length
+}
@@ -0,0 +1,65 @@
+package monitor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRangeSetAdd(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   rangeSet
+		begin int
+		end   int
+		gaps  []span
+		want  rangeSet
+	}{
+		{"空集合", nil, 0, 10, []span{{0, 10}}, rangeSet{{0, 10}}},
+		{"空区间", rangeSet{{0, 10}}, 5, 5, nil, rangeSet{{0, 10}}},
+		{"重复", rangeSet{{0, 10}}, 0, 10, nil, rangeSet{{0, 10}}},
+		{"包含于已有区间", rangeSet{{0, 10}}, 2, 8, nil, rangeSet{{0, 10}}},
+		{"相邻于后", rangeSet{{0, 10}}, 10, 20, []span{{10, 20}}, rangeSet{{0, 20}}},
+		{"相邻于前", rangeSet{{10, 20}}, 0, 10, []span{{0, 10}}, rangeSet{{0, 20}}},
+		{"乱序不相邻", rangeSet{{20, 30}}, 0, 10, []span{{0, 10}}, rangeSet{{0, 10}, {20, 30}}},
+		{"部分重叠", rangeSet{{0, 10}}, 5, 15, []span{{10, 15}}, rangeSet{{0, 15}}},
+		{"前部重叠", rangeSet{{10, 20}}, 5, 15, []span{{5, 10}}, rangeSet{{5, 20}}},
+		{"填补空洞", rangeSet{{0, 10}, {20, 30}}, 10, 20, []span{{10, 20}}, rangeSet{{0, 30}}},
+		{"跨越多个区间", rangeSet{{0, 10}, {20, 30}, {40, 50}}, 5, 45,
+			[]span{{10, 20}, {30, 40}}, rangeSet{{0, 50}}},
+		{"覆盖全部区间", rangeSet{{10, 20}, {30, 40}}, 0, 50,
+			[]span{{0, 10}, {20, 30}, {40, 50}}, rangeSet{{0, 50}}},
+		{"插入中间", rangeSet{{0, 10}, {40, 50}}, 20, 30, []span{{20, 30}}, rangeSet{{0, 10}, {20, 30}, {40, 50}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := append(rangeSet(nil), tt.set...)
+			gaps := set.add(tt.begin, tt.end)
+			if !reflect.DeepEqual(gaps, tt.gaps) {
+				t.Errorf("add(%d,%d)返回%v, 期望%v", tt.begin, tt.end, gaps, tt.gaps)
+			}
+			if !reflect.DeepEqual(set, tt.want) {
+				t.Errorf("add(%d,%d)后为%v, 期望%v", tt.begin, tt.end, set, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangeSetContiguous(t *testing.T) {
+	tests := []struct {
+		set    rangeSet
+		length int
+		want   bool
+	}{
+		{nil, 0, true},
+		{nil, 10, false},
+		{rangeSet{{0, 10}}, 10, true},
+		{rangeSet{{0, 10}}, 20, false},
+		{rangeSet{{5, 10}}, 10, false},
+		{rangeSet{{0, 5}, {6, 10}}, 10, false},
+	}
+	for _, tt := range tests {
+		if got := tt.set.contiguous(tt.length); got != tt.want {
+			t.Errorf("%v.contiguous(%d) = %v, 期望%v", tt.set, tt.length, got, tt.want)
+		}
+	}
+}
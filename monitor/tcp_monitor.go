@@ -4,8 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -20,42 +22,61 @@ type TCPContent struct {
 // TCPMonitor TCP包监视
 type TCPMonitor struct {
 	NetMonitor
+	heartbeat int //发送端心跳间隔(ms)，超过3倍间隔未收到数据时认为链路失效
 }
 
-func splitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	//FrameMagic+FrameType+Length 3个int32的长度
-	if !atEOF &&
-		len(data) > 4*3 &&
-		binary.BigEndian.Uint32(data[:4]) == packet.FrameMagic {
-		var frameType, length int32
-		err = binary.Read(bytes.NewReader(data[4:8]), binary.BigEndian, &length)
-		if err != nil {
-			log.Errorf("读取FrameLength出错%v", length)
-			return 0, nil, err
+// splitFrames 按帧头拆分数据帧，帧数据长度为负或超过maxLength时返回错误并关闭连接
+func splitFrames(maxLength int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		//FrameMagic+FrameType+Length 3个int32的长度
+		if len(data) >= 4 && binary.BigEndian.Uint32(data[:4]) != packet.FrameMagic {
+			return 0, nil, errors.New("数据帧MagicNumber不匹配")
 		}
-		err := binary.Read(bytes.NewReader(data[8:12]), binary.BigEndian, &frameType)
-		if err != nil {
-			log.Errorf("读取FrameType出错%v", frameType)
-			return 0, nil, err
+		if len(data) >= 4*3 {
+			var frameType, length int32
+			err = binary.Read(bytes.NewReader(data[4:8]), binary.BigEndian, &length)
+			if err != nil {
+				log.Errorf("读取FrameLength出错%v", length)
+				return 0, nil, err
+			}
+			err := binary.Read(bytes.NewReader(data[8:12]), binary.BigEndian, &frameType)
+			if err != nil {
+				log.Errorf("读取FrameType出错%v", frameType)
+				return 0, nil, err
+			}
+			if length < 0 || int(length) > maxLength {
+				return 0, nil, fmt.Errorf("数据帧长度%d超出范围[0,%d]", length, maxLength)
+			}
+			end := 4*3 + length
+			if len(data) >= int(end) {
+				log.Debugf("读取帧:%d,%d,%d,%d", frameType, length, end, len(data))
+				//消费end长的数据，返回从第4位开始的完整Frame数据
+				return int(end), data[4:end], nil
+			}
 		}
-		end := 4*3 + length
-		if len(data) >= int(end) {
-			log.Debugf("读取帧:%d,%d,%d,%d", frameType, length, end, len(data))
-			//消费end长的数据，返回从第4位开始的完整Frame数据
-			return int(end), data[4:end], nil
+		if atEOF && len(data) > 0 {
+			return 0, nil, errors.New("数据帧不完整")
 		}
+		return
 	}
-	return
 }
 
 func (monitor *TCPMonitor) readFrame(conn net.Conn) error {
 	defer conn.Close()
 	scanner := bufio.NewScanner(conn)
-	//TODO 数字常量定义，buf的大小应与MTU相关
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
-	scanner.Split(splitFunc)
-	for scanner.Scan() {
+	//数据帧最大为帧头加编码及签名后的分组
+	size := 4*3 + maxPacketSize(monitor.chunkSize)
+	buf := make([]byte, 0, size)
+	scanner.Buffer(buf, size)
+	scanner.Split(splitFrames(maxPacketSize(monitor.chunkSize)))
+	timeout := time.Duration(monitor.heartbeat*3) * time.Millisecond
+	for {
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+		if !scanner.Scan() {
+			break
+		}
 		data := scanner.Bytes()
 		frame := &packet.Frame{}
 		err := frame.Decode(data)
@@ -64,20 +85,31 @@ func (monitor *TCPMonitor) readFrame(conn net.Conn) error {
 		}
 
 		log.Debugf("收到数据帧:%v,%v", frame.FrameType, frame.Length)
+		if frame.FrameType == packet.FrameTypeHEARTBEAT {
+			continue
+		}
 		if frame.FrameType == packet.FrameTypeCLOSE {
 			log.Printf("接收到关闭通知")
 			//写接收标识
 			//conn.Write([]byte{0})
 			return nil
 		}
-		monitor.readPacket(frame)
+		monitor.readPacket(conn.RemoteAddr().String(), frame)
+	}
+	if err, ok := scanner.Err().(net.Error); ok && err.Timeout() {
+		log.Warn("TCP链路心跳超时，关闭连接", conn.RemoteAddr().String())
+		return err
 	}
 	return scanner.Err()
 }
 
-func (monitor *TCPMonitor) readPacket(frame *packet.Frame) {
+func (monitor *TCPMonitor) readPacket(source string, frame *packet.Frame) {
+	data, err := monitor.verify(source, frame.Data)
+	if err != nil {
+		return
+	}
 	pack := &packet.Packet{}
-	err := pack.Decode(frame.Data)
+	err = pack.Decode(data)
 	if err != nil {
 		log.Errorf("TCP数据帧解码错误: %s", err)
 		return
@@ -104,7 +136,12 @@ func (monitor *TCPMonitor) Start(onReady OnReady) {
 		if err != nil {
 			log.Error("接收连接出错:", err)
 		} else {
-			go monitor.readFrame(conn)
+			log.Println("接收TCP连接", conn.RemoteAddr().String())
+			go func() {
+				if err := monitor.readFrame(conn); err != nil {
+					log.Warn("TCP连接异常关闭", conn.RemoteAddr().String(), err)
+				}
+			}()
 		}
 	}
 }
@@ -0,0 +1,52 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/jamsa/hgap/packet"
+)
+
+// frame 编码帧头，length为帧头中声明的数据长度
+func frame(length int32, data []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, packet.FrameMagic)
+	binary.Write(&buf, binary.BigEndian, length)
+	binary.Write(&buf, binary.BigEndian, int32(packet.FrameTypeDATA))
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestSplitFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []byte
+		frames int
+		err    bool
+	}{
+		{"单个帧", frame(3, []byte("abc")), 1, false},
+		{"多个帧", append(frame(3, []byte("abc")), frame(0, nil)...), 2, false},
+		{"长度为负", frame(-10, []byte("abc")), 0, true},
+		{"长度超出上限", frame(17, bytes.Repeat([]byte("a"), 17)), 0, true},
+		{"帧不完整", frame(5, []byte("abc")), 0, true},
+		{"MagicNumber不匹配", append([]byte{0, 0, 0, 1}, frame(3, []byte("abc"))[4:]...), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := bufio.NewScanner(bytes.NewReader(tt.input))
+			scanner.Split(splitFrames(16))
+			frames := 0
+			for scanner.Scan() {
+				frames++
+			}
+			if frames != tt.frames {
+				t.Errorf("拆分出%d个帧, 期望%d个", frames, tt.frames)
+			}
+			if err := scanner.Err(); (err != nil) != tt.err {
+				t.Errorf("返回错误%v, 期望错误: %v", err, tt.err)
+			}
+		})
+	}
+}
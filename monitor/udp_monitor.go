@@ -18,10 +18,14 @@ type UDPMonitor struct {
 	NetMonitor
 }
 
-func (monitor *UDPMonitor) readPacket(buf []byte, n int) {
+func (monitor *UDPMonitor) readPacket(buf []byte, n int, addr *net.UDPAddr) {
 	//log.Printf("接收包长度:%v\n", n)
+	data, err := monitor.verify(addr.String(), buf[:n])
+	if err != nil {
+		return
+	}
 	pack := &packet.Packet{}
-	err := pack.Decode(buf[:n])
+	err = pack.Decode(data)
 	//err := gob.NewDecoder(bytes.NewReader(buf[:n])).Decode(pack)
 	if err != nil {
 		log.Errorf("UDP数据包解码错误: %s", err)
@@ -43,14 +47,15 @@ func (monitor *UDPMonitor) Start(onReady OnReady) {
 	log.Println("开始UDP包监视", listener.LocalAddr().String())
 
 	go monitor.cleanUp()
+	size := maxPacketSize(monitor.chunkSize)
 	for {
-		buf := make([]byte, packet.MTU*2)
+		buf := make([]byte, size)
 
-		n, _, err := listener.ReadFromUDP(buf)
+		n, addr, err := listener.ReadFromUDP(buf)
 		if err != nil {
 			log.Errorf("UDP数据读取错误: %s", err)
 			continue
 		}
-		go monitor.readPacket(buf, n)
+		go monitor.readPacket(buf, n, addr)
 	}
 }
@@ -2,25 +2,32 @@ package outbound
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
 	"io"
-	"io/ioutil"
+	"net"
 	"net/http"
-	"net/http/httputil"
-	"strings"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/jamsa/hgap/config"
+	"github.com/jamsa/hgap/gateway"
+	"github.com/jamsa/hgap/inspect"
+	"github.com/jamsa/hgap/journal"
 	"github.com/jamsa/hgap/monitor"
+	"github.com/jamsa/hgap/policy"
+	"github.com/jamsa/hgap/router"
 	"github.com/jamsa/hgap/transfer"
 )
 
 // OutBound 出站服务
 type OutBound struct {
-	monitor    monitor.IMonitor   //监控对象
-	transfer   transfer.ITransfer //传输对象
-	urlMapping map[string]string  //url映射
+	monitor   monitor.IMonitor   //监控对象
+	transfer  transfer.ITransfer //传输对象
+	router    *router.Router     //路由规则
+	client    *http.Client       //所有路由共享的上游客户端
+	policy    *policy.Policy     //全局安全策略，未配置时为nil
+	inspector *inspect.Pipeline  //响应内容检查，未启用时为nil
+	journal   *journal.Journal   //已处理请求记录
 }
 
 // New 构造器
@@ -33,10 +40,37 @@ func New(config *config.Config) (*OutBound, error) {
 	if err != nil {
 		return nil, err
 	}
+	transport := router.NewTransport(config)
+	router, err := router.New(config)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := policy.New(config.Policy)
+	if err != nil {
+		return nil, err
+	}
+	journal, err := journal.Open("outbound", config)
+	if err != nil {
+		return nil, err
+	}
+	inspector, err := inspect.New(false, config)
+	if err != nil {
+		return nil, err
+	}
 	result := &OutBound{
-		monitor:    monitor,
-		transfer:   transfer,
-		urlMapping: config.URLMapping,
+		monitor:   monitor,
+		transfer:  transfer,
+		router:    router,
+		journal:   journal,
+		policy:    policy,
+		inspector: inspector,
+		//不跟随重定向，由客户端自行处理
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
 	}
 	//monitor.SetOnReady(result.processRequest)
 	return result, nil
@@ -44,20 +78,10 @@ func New(config *config.Config) (*OutBound, error) {
 
 // Start 启动出站服务
 func (outbound *OutBound) Start() {
+	outbound.router.Start(outbound.client.Transport)
 	outbound.monitor.Start(outbound.processRequest)
 }
 
-// 重写url
-func (outbound *OutBound) rewriteURL(uri string) (string, bool) {
-	for k, v := range outbound.urlMapping {
-		if strings.HasPrefix(uri, k) {
-			url := strings.Replace(uri, k, v, 1)
-			return url, true
-		}
-	}
-	return "", false
-}
-
 // cleanUp 清理
 func (outbound *OutBound) cleanUp(reqID string) {
 	//只能立即清理monitor接收的数据
@@ -65,6 +89,115 @@ func (outbound *OutBound) cleanUp(reqID string) {
 	//transfer中发送的数据（如文件）不能立即清理
 }
 
+// sendResponse 将响应以流的方式交给transfer发送
+func (outbound *OutBound) sendResponse(reqID string, resp *http.Response) {
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		err := resp.Write(writer)
+		if err != nil {
+			log.Error("输出响应信息出错", err)
+		}
+		writer.CloseWithError(err)
+	}()
+	if err := outbound.transfer.Send(reqID, reader); err != nil {
+		log.Error("发送响应数据", reqID, "出错", err)
+		return
+	}
+	log.Println("写入响应数据完成:" + reqID)
+}
+
+// sendError 向入站端返回网关错误响应
+func (outbound *OutBound) sendError(reqID string, status int, reason string, err error) {
+	message := http.StatusText(status)
+	if err != nil {
+		message = err.Error()
+	}
+	log.WithField("reason", reason).Warn("返回网关错误响应:", reqID, " ", status)
+	outbound.sendResponse(reqID, gateway.ErrorResponse(status, reason, reqID, message))
+}
+
+// readError 根据读取请求数据的错误类型返回对应的网关错误
+func (outbound *OutBound) readError(reqID string, err error) {
+	switch {
+	case errors.Is(err, monitor.ErrMessageTooLarge):
+		outbound.sendError(reqID, http.StatusRequestEntityTooLarge, gateway.ReasonTooLarge, err)
+	case errors.Is(err, monitor.ErrOverloaded):
+		outbound.sendError(reqID, http.StatusServiceUnavailable, gateway.ReasonOverloaded, err)
+	default:
+		outbound.sendError(reqID, http.StatusBadGateway, gateway.ReasonBadRequest, err)
+	}
+}
+
+// checkPolicy 检查全局及路由的安全策略，违反时记录审计事件并返回403
+func (outbound *OutBound) checkPolicy(reqID string, route *router.Route, req *http.Request) bool {
+	err := outbound.policy.Check(req)
+	if err == nil {
+		err = route.Policy.Check(req)
+	}
+	if err == nil {
+		return true
+	}
+	outbound.policyDenied(reqID, route.Name, req, err)
+	return false
+}
+
+// policyDenied 记录违反安全策略的审计事件并返回403
+func (outbound *OutBound) policyDenied(reqID string, route string, req *http.Request, err error) {
+	log.WithFields(log.Fields{
+		"event":  "policy",
+		"route":  route,
+		"method": req.Method,
+		"uri":    req.RequestURI,
+		"client": req.Header.Get(gateway.ForwardedForHeader),
+	}).Warn("拒绝请求", reqID, ": ", err)
+	outbound.sendError(reqID, http.StatusForbidden, gateway.ReasonPolicyDenied, err)
+}
+
+// hasBody 响应是否带有Body
+func hasBody(req *http.Request, resp *http.Response) bool {
+	return req.Method != http.MethodHead && resp.ContentLength != 0 &&
+		resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified
+}
+
+// inspectResponse 检查响应Body，通过后以检查后的内容替换，被拦截时返回403
+func (outbound *OutBound) inspectResponse(reqID string, resp *http.Response) bool {
+	data, err := outbound.inspector.Inspect(reqID, resp.Header, resp.Body)
+	if err != nil {
+		var blocked *inspect.Blocked
+		if errors.As(err, &blocked) {
+			outbound.sendError(reqID, http.StatusForbidden, gateway.ReasonContentBlocked, err)
+		} else {
+			log.Error("读取响应数据", reqID, "出错", err)
+			outbound.sendError(reqID, http.StatusBadGateway, gateway.ReasonUpstreamError, err)
+		}
+		return false
+	}
+	resp.Body = inspect.NewBody(data)
+	resp.ContentLength = int64(len(data))
+	resp.TransferEncoding = nil
+	resp.Header.Del("Content-Length")
+	return true
+}
+
+// upstreamError 根据上游请求错误类型返回对应的网关错误
+func (outbound *OutBound) upstreamError(reqID string, route string, req *http.Request, err error) {
+	if errors.Is(err, policy.ErrBodyTooLarge) {
+		outbound.policyDenied(reqID, route, req, err)
+		return
+	}
+	//请求Body接收过程中被拒绝
+	if errors.Is(err, monitor.ErrMessageTooLarge) || errors.Is(err, monitor.ErrOverloaded) {
+		outbound.readError(reqID, err)
+		return
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		outbound.sendError(reqID, http.StatusGatewayTimeout, gateway.ReasonUpstreamTimeout, err)
+		return
+	}
+	outbound.sendError(reqID, http.StatusBadGateway, gateway.ReasonUpstreamError, err)
+}
+
 // 处理请求
 func (outbound *OutBound) processRequest(reqID string) {
 	defer func() {
@@ -81,52 +214,76 @@ func (outbound *OutBound) processRequest(reqID string) {
 	//outbound.monitor.Remove(reqID)
 	if err != nil {
 		log.Error("读取请求数据", reqID, "出错", err)
+		outbound.readError(reqID, err)
 		return
 	}
+	defer content.Close()
 
-	var buf = bufio.NewReader(strings.NewReader(string(content)))
-	req, err := http.ReadRequest(buf)
-	if err != nil && err != io.EOF {
+	//读取及校验通过后、执行请求前记录，重启后不会重复执行已处理过的请求(最多执行一次，执行中退出的请求不会重新执行)
+	if !outbound.journal.Claim(reqID) {
+		log.Println("请求已处理过，忽略:" + reqID)
+		return
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(content))
+	if err != nil {
 		log.Error("读取请求信息出错", err)
+		outbound.readError(reqID, err)
 		return
 	}
 
-	if url, ok := outbound.rewriteURL(req.RequestURI); ok {
-		log.Println("URL重写:", req.RequestURI, "  -->  ", url)
-		//
-		body, err := ioutil.ReadAll(req.Body)
-		if err != nil {
-			log.Error("读取请求Body出错", err)
-			return
-		}
-		//转发请求
-		proxyReq, err := http.NewRequest(req.Method, url, bytes.NewReader(body))
-		if err != nil {
-			log.Error("构造请求对象出错", err)
-			return
-		}
-		proxyReq.Header = make(http.Header)
-		for h, val := range req.Header {
-			proxyReq.Header[h] = val
-			//log.Println("#####:", h, "-----", val)
-		}
+	route, upstream, url, err := outbound.router.Match(req)
+	if errors.Is(err, router.ErrNoUpstream) {
+		log.Warn("路由", route.Name, "无可用的上游", req.RequestURI)
+		outbound.sendError(reqID, http.StatusServiceUnavailable, gateway.ReasonNoUpstream, err)
+		return
+	}
+	if err != nil {
+		log.Error("重写URL出错", req.RequestURI, err)
+		outbound.sendError(reqID, http.StatusBadGateway, gateway.ReasonBadRequest, err)
+		return
+	}
+	if route == nil {
+		log.Warn("无匹配的转发路径", req.Host, " ", req.Method, " ", req.RequestURI)
+		outbound.sendError(reqID, http.StatusBadGateway, gateway.ReasonNoRoute, nil)
+		return
+	}
+	log.Println("URL重写:", req.RequestURI, "  -->  ", url, " 路由:", route.Name)
+	//检查客户端的请求，长度未知的请求Body在转发过程中检查
+	if !outbound.checkPolicy(reqID, route, req) {
+		return
+	}
+	//转发请求，请求Body以流的方式读取
+	proxyReq, err := http.NewRequest(req.Method, url, req.Body)
+	if err != nil {
+		log.Error("构造请求对象出错", err)
+		outbound.sendError(reqID, http.StatusBadGateway, gateway.ReasonBadRequest, err)
+		return
+	}
+	proxyReq.ContentLength = req.ContentLength
+	//Connection中列出的头部已由InBound删除，此处不再按Connection删除，以免删除InBound添加的头部
+	gateway.RemoveHopHeaders(req.Header)
+	proxyReq.Header = make(http.Header)
+	gateway.CopyHeader(proxyReq.Header, req.Header)
+	route.PrepareRequest(req, proxyReq)
+	if outbound.inspector != nil {
+		//检查未压缩的响应内容
+		proxyReq.Header.Del("Accept-Encoding")
+	}
 
-		httpClient := &http.Client{}
-		resp, err := httpClient.Do(proxyReq)
-		if err != nil {
-			log.Error("执行请求时出错", err)
-			return
-		}
+	upstream.Acquire()
+	defer upstream.Release()
+	resp, err := outbound.client.Do(proxyReq)
+	if err != nil {
+		log.Error("执行请求时出错", err)
+		outbound.upstreamError(reqID, route.Name, req, err)
+		return
+	}
+	defer resp.Body.Close()
 
-		//保存响应
-		content, err := httputil.DumpResponse(resp, true)
-		if err != nil {
-			log.Error("Dump响应信息出错", err)
-			return
-		}
-		outbound.transfer.Send(reqID, content)
-		log.Println("写入响应数据完成:" + reqID)
+	route.RewriteResponse(req, resp)
+	if outbound.inspector != nil && hasBody(req, resp) && !outbound.inspectResponse(reqID, resp) {
 		return
 	}
-	log.Warn("无匹配的转发路径", req.RequestURI)
+	outbound.sendResponse(reqID, resp)
 }
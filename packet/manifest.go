@@ -0,0 +1,32 @@
+package packet
+
+import (
+	"encoding/json"
+)
+
+// 文件传输使用的扩展名
+const (
+	TempExt     = ".tmp"      //写入中的临时文件
+	ManifestExt = ".manifest" //清单文件
+)
+
+// Manifest 文件传输清单，记录数据文件的大小及校验和
+type Manifest struct {
+	ID     string `json:"id"`     //标识
+	Size   int64  `json:"size"`   //数据文件大小
+	SHA256 string `json:"sha256"` //数据文件SHA-256校验和(十六进制)
+}
+
+// Encode Manifest编码
+func (manifest *Manifest) Encode() ([]byte, error) {
+	return json.Marshal(manifest)
+}
+
+// DecodeManifest Manifest解码
+func DecodeManifest(data []byte) (*Manifest, error) {
+	result := &Manifest{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,7 @@
+package packet
+
+// 消息头，每个消息的数据流以1字节的压缩标志开始，之后为(压缩后的)消息数据
+const (
+	CompressNone byte = 0 //未压缩
+	CompressGzip byte = 1 //gzip压缩
+)
@@ -4,23 +4,114 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"hash/crc32"
 	"math"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// 分组数据长度定义
+const (
+	MTU          = 1024      //udp传输默认的分组数据长度
+	TCPChunkSize = MTU * 100 //tcp传输默认的分组数据长度
+	MaxUDPSize   = 65507     //UDP数据报的最大长度
+
+	legacyOverhead = 256 //gob编码增加的长度上限(类型描述及各字段)
 )
 
-// MTU 最大传输单元
-const MTU = 1024
+// ChunkSize 传输方式对应的分组数据长度，size为0时使用默认值
+func ChunkSize(transferType string, size int) int {
+	if size != 0 {
+		return size
+	}
+	if transferType == "tcp" {
+		return TCPChunkSize
+	}
+	return MTU
+}
+
+// Overhead 按version编码时分组增加的最大长度
+func Overhead(version int) int {
+	if version == VersionLegacy {
+		return legacyOverhead
+	}
+	return HeaderSize
+}
 
 // Packet 数据包分组
 type Packet struct {
 	ID     string //标识
-	Length int    //总长
+	Length int    //总长，流式传输时仅最后一个分组携带总长，其余分组为-1
 	Begin  int    //开始位置
 	Size   int    //数据长度
 	Data   []byte //数据
+	Parity int    //FEC校验分组序号，从1开始，0表示数据分组
+	Shards int    //FEC校验分组所属分块中的数据分组数量
 }
 
+// 分组头定义，分组头之后为数据，多字节字段均为大端字节序:
+//
+//	magic(2) version(1) flags(1) id(16) length(8) begin(8) size(4) parity(1) shards(1) crc(4)
+//
+// crc为分组头(不含crc)及数据的CRC32C校验和
+const (
+	PacketMagic   uint16 = 0x4847 //分组头的MagicNumber
+	VersionLegacy        = 0      //gob编码的旧版分组
+	Version              = 1      //当前的分组版本
+	HeaderSize           = 46     //分组头长度
+
+	flagFinal  = 0x01 //最后一个分组，携带总长
+	flagParity = 0x02 //FEC校验分组
+)
+
+// 分组解码错误
+var (
+	ErrVersion  = errors.New("不支持的分组版本")
+	ErrChecksum = errors.New("分组CRC校验失败")
+	ErrLength   = errors.New("分组长度不匹配")
+	ErrHeader   = errors.New("分组头数据无效")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
 // Encode Packet编码
 func (packet *Packet) Encode() ([]byte, error) {
+	id, err := uuid.FromString(packet.ID)
+	if err != nil {
+		return nil, err
+	}
+	if packet.Size != len(packet.Data) || packet.Parity > math.MaxUint8 || packet.Shards > math.MaxUint8 {
+		return nil, ErrLength
+	}
+	var flags byte
+	var length uint64
+	if packet.Length >= 0 {
+		flags |= flagFinal
+		length = uint64(packet.Length)
+	}
+	if packet.Parity > 0 {
+		flags |= flagParity
+	}
+
+	data := make([]byte, HeaderSize+packet.Size)
+	binary.BigEndian.PutUint16(data[0:], PacketMagic)
+	data[2] = Version
+	data[3] = flags
+	copy(data[4:20], id.Bytes())
+	binary.BigEndian.PutUint64(data[20:], length)
+	binary.BigEndian.PutUint64(data[28:], uint64(packet.Begin))
+	binary.BigEndian.PutUint32(data[36:], uint32(packet.Size))
+	data[40] = byte(packet.Parity)
+	data[41] = byte(packet.Shards)
+	copy(data[HeaderSize:], packet.Data)
+	crc := crc32.Update(crc32.Checksum(data[:42], crcTable), crcTable, data[HeaderSize:])
+	binary.BigEndian.PutUint32(data[42:], crc)
+	return data, nil
+}
+
+// EncodeLegacy 使用旧版发送端的gob格式编码Packet
+func (packet *Packet) EncodeLegacy() ([]byte, error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 	err := enc.Encode(packet)
@@ -31,63 +122,102 @@ func (packet *Packet) Encode() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// Decode Packet解码
+// Decode Packet解码，不以分组头开始的数据按gob编码的旧版分组解码
 func (packet *Packet) Decode(data []byte) error {
-	err := gob.NewDecoder(bytes.NewReader(data)).Decode(packet)
+	if len(data) < 2 || binary.BigEndian.Uint16(data) != PacketMagic {
+		err := gob.NewDecoder(bytes.NewReader(data)).Decode(packet)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+	if len(data) < HeaderSize {
+		return ErrLength
+	}
+	if data[2] != Version {
+		return ErrVersion
+	}
+	size := int(binary.BigEndian.Uint32(data[36:]))
+	if len(data) != HeaderSize+size {
+		return ErrLength
+	}
+	crc := crc32.Update(crc32.Checksum(data[:42], crcTable), crcTable, data[HeaderSize:])
+	if crc != binary.BigEndian.Uint32(data[42:]) {
+		return ErrChecksum
+	}
+	id, err := uuid.FromBytes(data[4:20])
 	if err != nil {
 		return err
 	}
+
+	flags := data[3]
+	packet.ID = id.String()
+	packet.Length = -1
+	if flags&flagFinal != 0 {
+		packet.Length = int(binary.BigEndian.Uint64(data[20:]))
+	}
+	packet.Begin = int(binary.BigEndian.Uint64(data[28:]))
+	packet.Size = size
+	packet.Data = append([]byte(nil), data[HeaderSize:]...)
+	packet.Parity = 0
+	packet.Shards = 0
+	if flags&flagParity != 0 {
+		if data[40] == 0 || data[41] == 0 {
+			return ErrHeader
+		}
+		packet.Parity = int(data[40])
+		packet.Shards = int(data[41])
+	}
 	return nil
 }
 
-// Iterator Packet 迭代器
-type Iterator struct {
-	id      string
-	size    int
-	data    []byte
-	current int
+// Writer 将写入的数据流切分为分组，最后一个分组在Close时发送并携带总长
+type Writer struct {
+	id     string              //标识
+	size   int                 //分组长
+	buf    []byte              //未发送的数据
+	offset int                 //已发送的数据长度
+	send   func(*Packet) error //分组发送回调
 }
 
-// HasNext 下一个
-func (iter *Iterator) HasNext() bool {
-	count := math.Ceil(float64(len(iter.data)) / float64(iter.size))
-	//log.Printf("HasNext:%v,%v,%v,%v,%v", len(iter.data), iter.size, iter.current, count, iter.current+1 <= int(count))
-	return iter.current+1 <= int(count)
+// NewWriter 新建分组写入器
+func NewWriter(id string, size int, send func(*Packet) error) *Writer {
+	return &Writer{
+		id:   id,
+		size: size,
+		send: send,
+	}
 }
 
-// Next 下一个
-func (iter *Iterator) Next() *Packet {
-	begin := iter.current * iter.size
-	end := (iter.current + 1) * iter.size
-	length := len(iter.data)
-
-	if begin >= length {
-		return nil
-	}
-	if end > length {
-		end = length
-	}
-	//log.Printf("%v,%v,%v", begin, end, length)
-	iter.current = iter.current + 1
-	result := Packet{
-		ID:     iter.id,
-		Length: length,
-		Begin:  begin,
-		Size:   end - begin, // iter.size
-		Data:   iter.data[begin:end],
+// Write 写入数据，缓存的数据超过一个分组时发送分组，总保留至少一个字节用于最后的分组
+func (writer *Writer) Write(p []byte) (int, error) {
+	writer.buf = append(writer.buf, p...)
+	for len(writer.buf) > writer.size {
+		if err := writer.flush(writer.size, -1); err != nil {
+			return 0, err
+		}
 	}
+	return len(p), nil
+}
 
-	return &result
+// Close 发送最后一个分组
+func (writer *Writer) Close() error {
+	return writer.flush(len(writer.buf), writer.offset+len(writer.buf))
 }
 
-// NewIterator 新建迭代器
-func NewIterator(id string, data []byte, size int) *Iterator {
-	return &Iterator{
-		id:      id,
-		size:    size, //1024, //分组长
-		data:    data,
-		current: 0,
+func (writer *Writer) flush(size int, length int) error {
+	data := make([]byte, size)
+	copy(data, writer.buf)
+	writer.buf = writer.buf[size:]
+	pack := &Packet{
+		ID:     writer.id,
+		Length: length,
+		Begin:  writer.offset,
+		Size:   size,
+		Data:   data,
 	}
+	writer.offset += size
+	return writer.send(pack)
 }
 
 // FrameType 数据帧类型
@@ -95,8 +225,9 @@ type FrameType int32
 
 // 数据帧类型定义
 const (
-	FrameTypeCLOSE FrameType = 0
-	FrameTypeDATA  FrameType = 1
+	FrameTypeCLOSE     FrameType = 0
+	FrameTypeDATA      FrameType = 1
+	FrameTypeHEARTBEAT FrameType = 2 //心跳帧，用于检测长连接是否失效
 )
 
 // FrameMagic Frame头的MagicNumber
@@ -125,8 +256,11 @@ func (frame *Frame) Encode() ([]byte, error) {
 // Decode Frame解码
 func (frame *Frame) Decode(data []byte) error {
 	var t, l int32
-	err := binary.Read(bytes.NewReader(data[:4]), binary.BigEndian, &t)
-	err = binary.Read(bytes.NewReader(data[4:8]), binary.BigEndian, &l)
+	err := binary.Read(bytes.NewReader(data[:4]), binary.BigEndian, &l)
+	if err != nil {
+		return err
+	}
+	err = binary.Read(bytes.NewReader(data[4:8]), binary.BigEndian, &t)
 	if err != nil {
 		return err
 	}
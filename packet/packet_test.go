@@ -0,0 +1,130 @@
+package packet
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		pack Packet
+	}{
+		{"数据分组", Packet{ID: testID, Length: -1, Begin: 1024, Size: 3, Data: []byte("abc")}},
+		{"最后的分组", Packet{ID: testID, Length: 1027, Begin: 1024, Size: 3, Data: []byte("abc")}},
+		{"空消息", Packet{ID: testID, Length: 0, Begin: 0, Size: 0}},
+		{"校验分组", Packet{ID: testID, Length: -1, Begin: 0, Size: 2, Data: []byte{1, 2}, Parity: 2, Shards: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.pack.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != HeaderSize+tt.pack.Size {
+				t.Errorf("编码长度%d, 期望%d", len(data), HeaderSize+tt.pack.Size)
+			}
+			var got Packet
+			if err = got.Decode(data); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.pack) {
+				t.Errorf("解码为%+v, 期望%+v", got, tt.pack)
+			}
+		})
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	pack := Packet{ID: testID, Length: 3, Begin: 0, Size: 3, Data: []byte("abc")}
+	data, err := pack.EncodeLegacy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Packet
+	if err = got.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, pack) {
+		t.Errorf("解码为%+v, 期望%+v", got, pack)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	pack := Packet{ID: testID, Length: -1, Begin: 0, Size: 3, Data: []byte("abc")}
+	valid, err := pack.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify := func(f func([]byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"分组头不完整", valid[:HeaderSize-1], ErrLength},
+		{"数据不完整", valid[:len(valid)-1], ErrLength},
+		{"数据过长", append(append([]byte(nil), valid...), 0), ErrLength},
+		{"版本", modify(func(d []byte) []byte { d[2] = Version + 1; return d }), ErrVersion},
+		{"数据被修改", modify(func(d []byte) []byte { d[HeaderSize] ^= 1; return d }), ErrChecksum},
+		{"分组头被修改", modify(func(d []byte) []byte { d[30] ^= 1; return d }), ErrChecksum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Packet
+			if err := got.Decode(tt.data); err != tt.err {
+				t.Errorf("返回%v, 期望%v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		sizes  []int
+	}{
+		{"空消息", nil, []int{0}},
+		{"不足一个分组", []string{"abc"}, []int{3}},
+		{"恰好一个分组", []string{"abcd"}, []int{4}},
+		{"多次写入", []string{"ab", "cdef", "ghi"}, []int{4, 4, 1}},
+		{"恰好整数个分组", []string{"abcdefgh"}, []int{4, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var packets []*Packet
+			writer := NewWriter(testID, 4, func(p *Packet) error {
+				packets = append(packets, p)
+				return nil
+			})
+			var body []byte
+			for _, w := range tt.writes {
+				writer.Write([]byte(w))
+				body = append(body, w...)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if len(packets) != len(tt.sizes) {
+				t.Fatalf("发送%d个分组, 期望%d个", len(packets), len(tt.sizes))
+			}
+			begin := 0
+			for i, p := range packets {
+				length := -1
+				if i == len(packets)-1 {
+					length = len(body)
+				}
+				if p.Begin != begin || p.Size != tt.sizes[i] || p.Length != length ||
+					string(p.Data) != string(body[begin:begin+p.Size]) {
+					t.Errorf("第%d个分组为%+v", i, p)
+				}
+				begin += p.Size
+			}
+		})
+	}
+}
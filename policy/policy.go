@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// ErrBodyTooLarge 请求Body超出长度上限
+var ErrBodyTooLarge = errors.New("请求Body超出长度上限")
+
+// Violation 违反安全策略的原因
+type Violation struct {
+	Rule   string //违反的规则
+	Detail string //详细信息
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("违反安全策略%s: %s", v.Rule, v.Detail)
+}
+
+// Policy 执行上游请求前检查的安全策略
+type Policy struct {
+	methods      []string         //允许的请求方法，为空时不限制
+	allowPaths   []*regexp.Regexp //允许的路径，为空时不限制
+	denyPaths    []*regexp.Regexp //禁止的路径
+	maxBodySize  int64            //请求Body长度上限，0表示不限制
+	contentTypes []string         //允许的请求Content-Type，为空时不限制
+	denyHeaders  []string         //禁止出现的请求头
+}
+
+// New 创建安全策略，未配置时返回nil
+func New(cfg *config.PolicyConfig) (*Policy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	policy := &Policy{
+		maxBodySize: cfg.MaxBodySize,
+		denyHeaders: cfg.DenyHeaders,
+	}
+	for _, m := range cfg.Methods {
+		policy.methods = append(policy.methods, strings.ToUpper(m))
+	}
+	for _, t := range cfg.ContentTypes {
+		policy.contentTypes = append(policy.contentTypes, strings.ToLower(t))
+	}
+	var err error
+	if policy.allowPaths, err = compile(cfg.AllowPaths); err != nil {
+		return nil, err
+	}
+	if policy.denyPaths, err = compile(cfg.DenyPaths); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func compile(patterns []string) ([]*regexp.Regexp, error) {
+	var result []*regexp.Regexp
+	for _, p := range patterns {
+		regex, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("安全策略的路径表达式%s有误: %v", p, err)
+		}
+		result = append(result, regex)
+	}
+	return result, nil
+}
+
+// Check 检查请求，违反策略时返回*Violation。长度未知的请求Body将在读取时检查，超出上限时读取返回ErrBodyTooLarge
+func (policy *Policy) Check(req *http.Request) error {
+	if policy == nil {
+		return nil
+	}
+	if len(policy.methods) > 0 && !contains(policy.methods, req.Method) {
+		return &Violation{Rule: "methods", Detail: "不允许的请求方法" + req.Method}
+	}
+	path := req.URL.Path
+	for _, regex := range policy.denyPaths {
+		if regex.MatchString(path) {
+			return &Violation{Rule: "denyPaths", Detail: "禁止访问的路径" + path}
+		}
+	}
+	if len(policy.allowPaths) > 0 {
+		allowed := false
+		for _, regex := range policy.allowPaths {
+			if regex.MatchString(path) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &Violation{Rule: "allowPaths", Detail: "不允许访问的路径" + path}
+		}
+	}
+	for _, h := range policy.denyHeaders {
+		if _, ok := req.Header[http.CanonicalHeaderKey(h)]; ok {
+			return &Violation{Rule: "denyHeaders", Detail: "禁止的请求头" + h}
+		}
+	}
+	hasBody := req.ContentLength != 0 && req.Body != nil && req.Body != http.NoBody
+	if hasBody && len(policy.contentTypes) > 0 {
+		mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil || !contains(policy.contentTypes, strings.ToLower(mediaType)) {
+			return &Violation{Rule: "contentTypes", Detail: "不允许的Content-Type:" + req.Header.Get("Content-Type")}
+		}
+	}
+	if hasBody && policy.maxBodySize > 0 {
+		if req.ContentLength > policy.maxBodySize {
+			return &Violation{Rule: "maxBodySize", Detail: fmt.Sprintf("请求Body长度%d超出上限%d", req.ContentLength, policy.maxBodySize)}
+		}
+		if req.ContentLength < 0 {
+			req.Body = &limitReader{ReadCloser: req.Body, remain: policy.maxBodySize}
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// limitReader 读取超出长度上限时返回ErrBodyTooLarge
+type limitReader struct {
+	io.ReadCloser
+	remain int64
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.remain -= int64(n)
+	if r.remain < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jamsa/hgap/config"
+)
+
+func TestCheck(t *testing.T) {
+	cfg := &config.PolicyConfig{
+		Methods:      []string{"get", "POST"},
+		AllowPaths:   []string{"^/api/", "^/static/"},
+		DenyPaths:    []string{"^/api/admin"},
+		MaxBodySize:  10,
+		ContentTypes: []string{"application/json", "Text/Plain"},
+		DenyHeaders:  []string{"x-debug"},
+	}
+	policy, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		header map[string]string
+		rule   string //违反的规则，为空表示放行
+	}{
+		{"放行", "GET", "/api/users", "", nil, ""},
+		{"方法不区分大小写配置", "POST", "/api/users", `{"a":1}`, map[string]string{"Content-Type": "application/json"}, ""},
+		{"不允许的方法", "DELETE", "/api/users", "", nil, "methods"},
+		{"禁止的路径优先于允许的路径", "GET", "/api/admin/users", "", nil, "denyPaths"},
+		{"不在允许的路径中", "GET", "/other", "", nil, "allowPaths"},
+		{"路径匹配不受查询参数影响", "GET", "/static/a.js?x=/api/admin", "", nil, ""},
+		{"禁止的请求头", "GET", "/api/users", "", map[string]string{"X-Debug": "1"}, "denyHeaders"},
+		{"带参数的Content-Type", "POST", "/api/users", "hi", map[string]string{"Content-Type": "text/plain; charset=utf-8"}, ""},
+		{"不允许的Content-Type", "POST", "/api/users", "<a/>", map[string]string{"Content-Type": "text/xml"}, "contentTypes"},
+		{"缺少Content-Type", "POST", "/api/users", "{}", nil, "contentTypes"},
+		{"无Body时不检查Content-Type", "POST", "/api/users", "", nil, ""},
+		{"Body超出上限", "POST", "/api/users", `{"a":"12345"}`, map[string]string{"Content-Type": "application/json"}, "maxBodySize"},
+		{"Body等于上限", "POST", "/api/users", `{"a":"12"}`, map[string]string{"Content-Type": "application/json"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.body == "" {
+				req = httptest.NewRequest(tt.method, tt.target, nil)
+			} else {
+				req = httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			err := policy.Check(req)
+			if tt.rule == "" {
+				if err != nil {
+					t.Errorf("应放行, 返回%v", err)
+				}
+				return
+			}
+			var violation *Violation
+			if !errors.As(err, &violation) {
+				t.Fatalf("返回%v, 期望违反%s", err, tt.rule)
+			}
+			if violation.Rule != tt.rule {
+				t.Errorf("违反%s, 期望%s", violation.Rule, tt.rule)
+			}
+		})
+	}
+}
+
+func TestCheckNil(t *testing.T) {
+	policy, err := New(nil)
+	if err != nil || policy != nil {
+		t.Fatalf("未配置时返回%v, %v", policy, err)
+	}
+	if err = policy.Check(httptest.NewRequest("DELETE", "/any", nil)); err != nil {
+		t.Errorf("未配置时应放行, 返回%v", err)
+	}
+}
+
+func TestNewInvalidPattern(t *testing.T) {
+	if _, err := New(&config.PolicyConfig{DenyPaths: []string{"("}}); err == nil {
+		t.Error("路径表达式有误时应返回错误")
+	}
+}
+
+func TestLimitReader(t *testing.T) {
+	policy, err := New(&config.PolicyConfig{MaxBodySize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		body string
+		err  error
+	}{
+		{"未超出上限", "12345", nil},
+		{"等于上限", "1234567890", nil},
+		{"超出上限", "12345678901", ErrBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			//长度未知的Body(如chunked编码)在读取时检查
+			req.ContentLength = -1
+			if err := policy.Check(req); err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(req.Body)
+			if err != tt.err {
+				t.Fatalf("读取返回%v, 期望%v", err, tt.err)
+			}
+			if err == nil && string(data) != tt.body {
+				t.Errorf("读取%q, 期望%q", data, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// 负载均衡方式
+const (
+	BalanceRoundRobin = "round-robin" //轮询
+	BalanceLeastConn  = "least-conn"  //最少连接
+	BalanceHash       = "hash"        //按客户端hash
+)
+
+// ErrNoUpstream 没有可用的上游
+var ErrNoUpstream = errors.New("没有可用的上游")
+
+// Upstream 上游目标
+type Upstream struct {
+	Target  string //目标URL，作为路由的target进行重写
+	origin  string //协议及主机，用于健康检查
+	active  int32  //正在执行的请求数
+	down    int32  //为1时已移出轮转
+	fails   int    //连续检查失败次数
+	success int    //连续检查成功次数
+}
+
+// Acquire 开始一个请求
+func (upstream *Upstream) Acquire() {
+	atomic.AddInt32(&upstream.active, 1)
+}
+
+// Release 结束一个请求
+func (upstream *Upstream) Release() {
+	atomic.AddInt32(&upstream.active, -1)
+}
+
+// healthy 是否在轮转中
+func (upstream *Upstream) healthy() bool {
+	return atomic.LoadInt32(&upstream.down) == 0
+}
+
+// pool 路由的上游目标集合
+type pool struct {
+	upstreams  []*Upstream
+	balance    string
+	hashHeader string
+	check      *config.HealthCheckConfig
+	next       uint32 //轮询计数
+}
+
+// newPool 创建上游集合
+func newPool(targets []string, balance string, hashHeader string, check *config.HealthCheckConfig) (*pool, error) {
+	switch balance {
+	case "":
+		balance = BalanceRoundRobin
+	case BalanceRoundRobin, BalanceLeastConn, BalanceHash:
+	default:
+		return nil, fmt.Errorf("负载均衡方式%s不支持", balance)
+	}
+	if hashHeader == "" {
+		hashHeader = "X-Forwarded-For"
+	}
+	p := &pool{
+		balance:    balance,
+		hashHeader: hashHeader,
+		check:      check,
+	}
+	for _, target := range targets {
+		if target == "" {
+			return nil, errors.New("目标URL为空")
+		}
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("目标URL%s有误: %v", target, err)
+		}
+		p.upstreams = append(p.upstreams, &Upstream{
+			Target: target,
+			origin: u.Scheme + "://" + u.Host,
+		})
+	}
+	if len(p.upstreams) == 0 {
+		return nil, errors.New("未配置目标URL")
+	}
+	return p, nil
+}
+
+// pick 按负载均衡方式从可用的上游中选择一个
+func (p *pool) pick(req *http.Request) (*Upstream, error) {
+	if len(p.upstreams) == 1 && p.check == nil {
+		return p.upstreams[0], nil
+	}
+	var healthy []*Upstream
+	for _, upstream := range p.upstreams {
+		if upstream.healthy() {
+			healthy = append(healthy, upstream)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, ErrNoUpstream
+	}
+	switch p.balance {
+	case BalanceLeastConn:
+		result := healthy[0]
+		for _, upstream := range healthy[1:] {
+			if atomic.LoadInt32(&upstream.active) < atomic.LoadInt32(&result.active) {
+				result = upstream
+			}
+		}
+		return result, nil
+	case BalanceHash:
+		//FNV的低位分布不均，取高位选择上游
+		h := fnv.New64a()
+		h.Write([]byte(clientKey(req, p.hashHeader)))
+		return healthy[(h.Sum64()>>32)%uint64(len(healthy))], nil
+	default:
+		n := atomic.AddUint32(&p.next, 1)
+		return healthy[(n-1)%uint32(len(healthy))], nil
+	}
+}
+
+// clientKey 标识客户端的值，X-Forwarded-For等列表形式的请求头取第一个地址
+func clientKey(req *http.Request, header string) string {
+	value := req.Header.Get(header)
+	if i := strings.Index(value, ","); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// healthCheck 定时检查上游，连续失败达到阈值时移出轮转，连续成功达到阈值时恢复
+func (p *pool) healthCheck(name string, transport http.RoundTripper) {
+	interval := time.Duration(p.check.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	timeout := time.Duration(p.check.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	unhealthy, healthy := p.check.Unhealthy, p.check.Healthy
+	if unhealthy <= 0 {
+		unhealthy = 3
+	}
+	if healthy <= 0 {
+		healthy = 2
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for {
+		for _, upstream := range p.upstreams {
+			err := probe(client, upstream.origin+p.check.Path)
+			if err != nil {
+				upstream.success = 0
+				upstream.fails++
+				log.Debugf("路由%s的上游%s检查失败: %v", name, upstream.origin, err)
+				if upstream.fails >= unhealthy && atomic.CompareAndSwapInt32(&upstream.down, 0, 1) {
+					log.WithField("event", "upstream").Warnf("路由%s的上游%s不可用，移出轮转: %v", name, upstream.origin, err)
+				}
+				continue
+			}
+			upstream.fails = 0
+			upstream.success++
+			if upstream.success >= healthy && atomic.CompareAndSwapInt32(&upstream.down, 1, 0) {
+				log.WithField("event", "upstream").Infof("路由%s的上游%s已恢复，加入轮转", name, upstream.origin)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// probe 执行一次检查，2xx、3xx响应视为正常
+func probe(client *http.Client, target string) error {
+	resp, err := client.Get(target)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return errors.New("检查超时")
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("检查返回%d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package router
+
+import (
+	"bytes"
+	"io"
+)
+
+// replacement 替换规则
+type replacement struct {
+	from []byte
+	to   []byte
+}
+
+// replaceReader 以流的方式替换内容，保留可能跨越两次读取的末尾数据
+type replaceReader struct {
+	source  io.Reader
+	rules   []replacement
+	keep    int    //未确定是否匹配时需保留的最大长度
+	buf     []byte //读取缓冲区
+	pending []byte //已读取未处理的数据
+	output  []byte //已处理未返回的数据
+	err     error  //读取source的错误
+}
+
+// newReplaceReader 创建替换读取器
+func newReplaceReader(source io.Reader, rules []replacement) *replaceReader {
+	keep := 1
+	for _, rule := range rules {
+		if len(rule.from) > keep {
+			keep = len(rule.from)
+		}
+	}
+	return &replaceReader{
+		source: source,
+		rules:  rules,
+		keep:   keep - 1,
+		buf:    make([]byte, 32*1024),
+	}
+}
+
+// Read 读取替换后的数据
+func (r *replaceReader) Read(p []byte) (int, error) {
+	for len(r.output) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		n, err := r.source.Read(r.buf)
+		r.pending = append(r.pending, r.buf[:n]...)
+		r.err = err
+		r.process(err != nil)
+	}
+	n := copy(p, r.output)
+	r.output = r.output[n:]
+	return n, nil
+}
+
+// process 替换pending中的数据，未结束时保留末尾可能不完整的匹配
+func (r *replaceReader) process(final bool) {
+	data := r.pending
+	for {
+		pos, rule := -1, -1
+		for i, v := range r.rules {
+			if j := bytes.Index(data, v.from); j >= 0 && (pos < 0 || j < pos) {
+				pos, rule = j, i
+			}
+		}
+		//未结束时，更早开始的较长规则可能尚未读取完整，留待下次处理
+		if pos < 0 || (!final && pos >= len(data)-r.keep) {
+			break
+		}
+		r.output = append(r.output, data[:pos]...)
+		r.output = append(r.output, r.rules[rule].to...)
+		data = data[pos+len(r.rules[rule].from):]
+	}
+	tail := 0
+	if !final && len(data) > r.keep {
+		tail = r.keep
+	} else if !final {
+		tail = len(data)
+	}
+	r.output = append(r.output, data[:len(data)-tail]...)
+	r.pending = append([]byte(nil), data[len(data)-tail:]...)
+}
@@ -0,0 +1,76 @@
+package router
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReplaceReader(t *testing.T) {
+	rules := []replacement{
+		{[]byte("http://internal:8080"), []byte("https://public.example.com")},
+		{[]byte("internal"), []byte("public")},
+		{[]byte("ab"), []byte("")},
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"无匹配", "hello world", "hello world"},
+		{"空内容", "", ""},
+		{"单个匹配", "go to http://internal:8080/x", "go to https://public.example.com/x"},
+		{"多个匹配", "internal internal", "public public"},
+		{"优先最早的匹配", "internal http://internal:8080", "public https://public.example.com"},
+		{"替换为空", "xabyabz", "xyz"},
+		{"替换结果不再匹配", "aabb", "ab"},
+		{"末尾的不完整匹配", "url http://internal:80", "url http://public:80"},
+		{"末尾的部分匹配", "inter", "inter"},
+	}
+	for _, tt := range tests {
+		readers := map[string]func(io.Reader) io.Reader{
+			"整体读取":  func(r io.Reader) io.Reader { return r },
+			"逐字节读取": iotest.OneByteReader,
+			"分半读取":  iotest.HalfReader,
+		}
+		for mode, wrap := range readers {
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				r := newReplaceReader(wrap(strings.NewReader(tt.input)), rules)
+				got, err := ioutil.ReadAll(iotest.OneByteReader(r))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("替换为%q, 期望%q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestReplaceReaderAcrossBuffer(t *testing.T) {
+	//匹配跨越内部缓冲区的边界
+	prefix := strings.Repeat("x", 32*1024-3)
+	input := prefix + "internal" + prefix
+	r := newReplaceReader(strings.NewReader(input), []replacement{{[]byte("internal"), []byte("public")}})
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := prefix + "public" + prefix; string(got) != want {
+		t.Errorf("替换结果长度%d, 期望%d", len(got), len(want))
+	}
+}
+
+func TestReplaceReaderNoRules(t *testing.T) {
+	r := newReplaceReader(iotest.OneByteReader(strings.NewReader("unchanged")), nil)
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "unchanged" {
+		t.Errorf("读取%q, 期望%q", got, "unchanged")
+	}
+}
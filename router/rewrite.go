@@ -0,0 +1,225 @@
+package router
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// defaultBodyTypes 默认重写内容的Content-Type
+var defaultBodyTypes = []string{
+	"text/html",
+	"text/css",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"text/xml",
+}
+
+// urlMapping 上游地址与客户端访问地址的对应关系
+type urlMapping struct {
+	from     string //上游地址前缀
+	to       string //客户端访问地址前缀
+	fromPath string //上游路径前缀，用于Cookie的Path
+	toPath   string //客户端访问路径前缀
+	host     string //上游主机，用于Cookie的Domain
+}
+
+// mappings 计算各上游与客户端访问地址的对应关系
+func (route *Route) mappings(req *http.Request) []urlMapping {
+	public := route.response.PublicURL
+	if public == "" {
+		scheme := req.Header.Get("X-Forwarded-Proto")
+		if scheme == "" {
+			scheme = "http"
+		}
+		host := req.Header.Get("X-Forwarded-Host")
+		if host == "" {
+			host = req.Host
+		}
+		public = scheme + "://" + host
+	}
+	public = strings.TrimSuffix(public, "/")
+
+	var result []urlMapping
+	for _, upstream := range route.pool.upstreams {
+		u, err := url.Parse(upstream.Target)
+		if err != nil {
+			continue
+		}
+		m := urlMapping{
+			from: upstream.origin,
+			to:   public,
+			host: u.Hostname(),
+		}
+		//前缀路由的上游路径与客户端路径一一对应，其它路由只替换协议及主机
+		if route.Match == MatchPrefix && route.StripPrefix == "" && route.AddPrefix == "" {
+			m.fromPath = strings.TrimSuffix(u.EscapedPath(), "/")
+			m.toPath = strings.TrimSuffix(route.Path, "/")
+			m.from += m.fromPath
+			m.to += m.toPath
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// rewriteURL 将指向上游的URL改为客户端访问的URL，前缀路由同时调整以/开头的相对路径
+func rewriteURL(value string, mappings []urlMapping) string {
+	for _, m := range mappings {
+		if rest, ok := trimPrefix(value, m.from); ok {
+			return m.to + rest
+		}
+	}
+	if !strings.HasPrefix(value, "/") || strings.HasPrefix(value, "//") {
+		return value
+	}
+	for _, m := range mappings {
+		if m.fromPath == m.toPath {
+			continue
+		}
+		if rest, ok := trimPrefix(value, m.fromPath); ok {
+			if value = m.toPath + rest; value == "" || value[0] != '/' {
+				value = "/" + value
+			}
+			return value
+		}
+	}
+	return value
+}
+
+// trimPrefix 去除完整路径段的前缀，prefix之后须为结尾或/、?、#
+func trimPrefix(value string, prefix string) (string, bool) {
+	if !strings.HasPrefix(value, prefix) {
+		return "", false
+	}
+	rest := value[len(prefix):]
+	if rest == "" || strings.ContainsAny(rest[:1], "/?#") {
+		return rest, true
+	}
+	return "", false
+}
+
+// rewriteCookie 重写Set-Cookie中的Domain及Path
+func rewriteCookie(cookie string, mappings []urlMapping) string {
+	parts := strings.Split(cookie, ";")
+	result := parts[:1]
+	for _, part := range parts[1:] {
+		attr := strings.TrimSpace(part)
+		name := strings.ToLower(attr)
+		switch {
+		case strings.HasPrefix(name, "domain="):
+			domain := strings.TrimPrefix(strings.ToLower(attr[len("domain="):]), ".")
+			matched := false
+			for _, m := range mappings {
+				if domain == m.host || strings.HasSuffix(m.host, "."+domain) {
+					matched = true
+					break
+				}
+			}
+			//去除上游的Domain，由浏览器使用InBound的主机
+			if matched {
+				continue
+			}
+		case strings.HasPrefix(name, "path="):
+			path := attr[len("path="):]
+			for _, m := range mappings {
+				if m.fromPath == m.toPath {
+					continue
+				}
+				if path == m.fromPath || strings.HasPrefix(path, m.fromPath+"/") {
+					path = m.toPath + path[len(m.fromPath):]
+					break
+				}
+			}
+			if path == "" {
+				path = "/"
+			}
+			attr = "Path=" + path
+		}
+		result = append(result, " "+attr)
+	}
+	return strings.Join(result, ";")
+}
+
+// RewriteResponse 按路由的响应重写规则调整上游响应
+func (route *Route) RewriteResponse(req *http.Request, resp *http.Response) {
+	if route.response == nil {
+		return
+	}
+	mappings := route.mappings(req)
+	if route.response.Location {
+		for _, h := range []string{"Location", "Content-Location"} {
+			if v := resp.Header.Get(h); v != "" {
+				if nv := rewriteURL(v, mappings); nv != v {
+					log.Debugf("重写%s: %s -> %s", h, v, nv)
+					resp.Header.Set(h, nv)
+				}
+			}
+		}
+	}
+	if route.response.Cookie {
+		if cookies := resp.Header.Values("Set-Cookie"); len(cookies) > 0 {
+			rewritten := make([]string, len(cookies))
+			for i, v := range cookies {
+				rewritten[i] = rewriteCookie(v, mappings)
+			}
+			resp.Header["Set-Cookie"] = rewritten
+		}
+	}
+	if route.response.Body && route.bodyType(resp) {
+		var rules []replacement
+		for _, m := range mappings {
+			rules = append(rules, replacement{from: []byte(m.from), to: []byte(m.to)})
+		}
+		resp.Body = &readCloser{
+			Reader: newReplaceReader(resp.Body, rules),
+			Closer: resp.Body,
+		}
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+	}
+}
+
+// bodyType 响应内容是否需要重写
+func (route *Route) bodyType(resp *http.Response) bool {
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		log.Debug("响应内容已压缩，不重写:", enc)
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	types := route.response.BodyTypes
+	if len(types) == 0 {
+		types = defaultBodyTypes
+	}
+	for _, t := range types {
+		if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseRewrite 未启用任何重写时返回nil
+func responseRewrite(cfg *config.RewriteConfig) *config.RewriteConfig {
+	if cfg == nil || (!cfg.Location && !cfg.Cookie && !cfg.Body) {
+		return nil
+	}
+	return cfg
+}
+
+// readCloser 组合Reader及Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jamsa/hgap/config"
+)
+
+func newTestRoute(t *testing.T, rc *config.RouteConfig) *Route {
+	rc.Rewrite = &config.RewriteConfig{Location: true}
+	router, err := New(&config.Config{Routes: []*config.RouteConfig{rc}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router.routes[0]
+}
+
+func TestRewriteLocation(t *testing.T) {
+	bing := newTestRoute(t, &config.RouteConfig{Path: "/bing", Target: "https://cn.bing.com/"})
+	app := newTestRoute(t, &config.RouteConfig{Path: "/", Target: "http://internal:8080/app/"})
+	exact := newTestRoute(t, &config.RouteConfig{Match: MatchExact, Path: "/bing", Target: "https://cn.bing.com/"})
+	tests := []struct {
+		name     string
+		route    *Route
+		location string
+		want     string
+	}{
+		{"绝对地址", bing, "https://cn.bing.com/search?q=go", "http://gateway/bing/search?q=go"},
+		{"相对路径", bing, "/search?q=go", "/bing/search?q=go"},
+		{"根路径", bing, "/", "/bing/"},
+		{"协议相对地址不改写", bing, "//other.com/search", "//other.com/search"},
+		{"其它主机不改写", bing, "https://other.com/search", "https://other.com/search"},
+		{"非/开头的相对路径不改写", bing, "search", "search"},
+		{"上游路径前缀", app, "/app/login", "/login"},
+		{"上游路径本身", app, "/app", "/"},
+		{"不完整的路径段不改写", app, "/application", "/application"},
+		{"上游路径之外不改写", app, "/other", "/other"},
+		{"非前缀路由不改写相对路径", exact, "/search", "/search"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://gateway/", nil)
+			resp := &http.Response{Header: http.Header{}}
+			resp.Header.Set("Location", tt.location)
+			resp.Header.Set("Content-Location", tt.location)
+			tt.route.RewriteResponse(req, resp)
+			for _, h := range []string{"Location", "Content-Location"} {
+				if got := resp.Header.Get(h); got != tt.want {
+					t.Errorf("%s重写为%q, 期望%q", h, got, tt.want)
+				}
+			}
+		})
+	}
+}
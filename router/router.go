@@ -0,0 +1,362 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jamsa/hgap/config"
+	"github.com/jamsa/hgap/policy"
+)
+
+// 路由匹配方式
+const (
+	MatchPrefix = "prefix" //前缀匹配，匹配的前缀替换为target
+	MatchExact  = "exact"  //路径完全匹配，路径替换为target，保留查询参数
+	MatchRegex  = "regex"  //正则表达式匹配请求URI，target中可使用$1、${name}引用分组
+)
+
+// MatchAny 请求头或查询参数存在即匹配
+const MatchAny = "*"
+
+// Route 路由规则
+type Route struct {
+	Name         string                //名称
+	Match        string                //匹配方式
+	Path         string                //匹配的路径、前缀或正则表达式
+	Targets      []string              //转发的目标URL，多个时按负载均衡方式选择
+	Balance      string                //负载均衡方式
+	Host         string                //匹配的Host，支持*.example.com形式的通配，为空时不限制
+	Methods      []string              //匹配的请求方法，为空时不限制
+	Headers      map[string]string     //匹配的请求头
+	Query        map[string]string     //匹配的查询参数
+	StripPrefix  string                //从转发URL的路径中去除的前缀
+	AddPrefix    string                //在转发URL的路径前添加的前缀
+	SetHeaders   map[string]string     //转发时设置的请求头，值为空时删除该请求头
+	PreserveHost bool                  //转发时保留客户端请求的Host
+	HostHeader   string                //转发时使用的Host
+	regex        *regexp.Regexp        //正则表达式匹配方式下编译后的Path
+	pool         *pool                 //上游目标集合
+	response     *config.RewriteConfig //响应重写，未启用时为nil
+	Policy       *policy.Policy        //路由的安全策略，未配置时为nil
+}
+
+// String 路由规则描述
+func (route *Route) String() string {
+	var conds []string
+	if route.Host != "" {
+		conds = append(conds, "host="+route.Host)
+	}
+	if len(route.Methods) > 0 {
+		conds = append(conds, "method="+strings.Join(route.Methods, ","))
+	}
+	for _, k := range sortedKeys(route.Headers) {
+		conds = append(conds, "header."+k+"="+route.Headers[k])
+	}
+	for _, k := range sortedKeys(route.Query) {
+		conds = append(conds, "query."+k+"="+route.Query[k])
+	}
+	target := strings.Join(route.Targets, ",")
+	if len(route.Targets) > 1 {
+		target = route.Balance + "(" + target + ")"
+	}
+	desc := fmt.Sprintf("%s[%s %s -> %s", route.Name, route.Match, route.Path, target)
+	if len(conds) > 0 {
+		desc += " when " + strings.Join(conds, " ")
+	}
+	return desc + "]"
+}
+
+// matches 检查请求的Host、方法、请求头及查询参数
+func (route *Route) matches(req *http.Request) bool {
+	if route.Host != "" && !matchHost(route.Host, req.Host) {
+		return false
+	}
+	if len(route.Methods) > 0 {
+		found := false
+		for _, m := range route.Methods {
+			if strings.EqualFold(m, req.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range route.Headers {
+		if !matchValues(v, req.Header.Values(k)) {
+			return false
+		}
+	}
+	if len(route.Query) > 0 {
+		query := req.URL.Query()
+		for k, v := range route.Query {
+			if !matchValues(v, query[k]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchHost 匹配Host，规则中未指定端口时忽略请求的端口
+func matchHost(pattern string, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+	if !strings.Contains(pattern, ":") {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// matchValues 任一值与期望值相同即匹配，期望值为*时存在即匹配
+func matchValues(expect string, values []string) bool {
+	if expect == MatchAny {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if v == expect {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite 匹配请求URI，返回按目标URL重写后的URL
+func (route *Route) rewrite(uri string, target string) (string, bool) {
+	switch route.Match {
+	case MatchExact:
+		path, query := splitQuery(uri)
+		if path != route.Path {
+			return "", false
+		}
+		if query == "" {
+			return target, true
+		}
+		if strings.Contains(target, "?") {
+			return target + "&" + query, true
+		}
+		return target + "?" + query, true
+	case MatchRegex:
+		match := route.regex.FindStringSubmatchIndex(uri)
+		if match == nil {
+			return "", false
+		}
+		return string(route.regex.ExpandString(nil, target, uri, match)), true
+	default:
+		if !strings.HasPrefix(uri, route.Path) {
+			return "", false
+		}
+		rest := uri[len(route.Path):]
+		//避免拼接出连续的/
+		if strings.HasSuffix(target, "/") && strings.HasPrefix(rest, "/") {
+			rest = rest[1:]
+		}
+		return target + rest, true
+	}
+}
+
+// rewritePrefix 按StripPrefix、AddPrefix调整转发URL的路径
+func (route *Route) rewritePrefix(target string) (string, error) {
+	if route.StripPrefix == "" && route.AddPrefix == "" {
+		return target, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	path := u.EscapedPath()
+	if route.StripPrefix != "" && strings.HasPrefix(path, route.StripPrefix) {
+		path = path[len(route.StripPrefix):]
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	if route.AddPrefix != "" {
+		path = strings.TrimSuffix(route.AddPrefix, "/") + path
+	}
+	if u.Path, err = url.PathUnescape(path); err != nil {
+		return "", err
+	}
+	u.RawPath = path
+	return u.String(), nil
+}
+
+// PrepareRequest 按路由设置转发请求的Host及请求头
+func (route *Route) PrepareRequest(req *http.Request, proxyReq *http.Request) {
+	if route.HostHeader != "" {
+		proxyReq.Host = route.HostHeader
+	} else if route.PreserveHost {
+		proxyReq.Host = req.Host
+	}
+	for k, v := range route.SetHeaders {
+		if v == "" {
+			proxyReq.Header.Del(k)
+		} else {
+			proxyReq.Header.Set(k, v)
+		}
+	}
+	//重写响应内容时要求上游不压缩响应
+	if route.response != nil && route.response.Body {
+		proxyReq.Header.Del("Accept-Encoding")
+	}
+}
+
+// splitQuery 拆分请求URI中的路径及查询参数
+func splitQuery(uri string) (string, string) {
+	if i := strings.Index(uri, "?"); i >= 0 {
+		return uri[:i], uri[i+1:]
+	}
+	return uri, ""
+}
+
+// sortedKeys 按字母顺序返回map的key
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Router 按顺序匹配路由规则，第一个匹配的规则生效
+type Router struct {
+	routes []*Route
+}
+
+// New 创建路由，routes中的规则按配置顺序匹配，之后为urlMapping中的前缀规则，按前缀由长到短匹配
+func New(cfg *config.Config) (*Router, error) {
+	var err error
+	router := &Router{}
+	for i, rc := range cfg.Routes {
+		route := &Route{
+			Name:         rc.Name,
+			Match:        rc.Match,
+			Path:         rc.Path,
+			Targets:      rc.Targets,
+			Balance:      rc.Balance,
+			Host:         rc.Host,
+			Methods:      rc.Methods,
+			Headers:      rc.Headers,
+			Query:        rc.Query,
+			StripPrefix:  rc.StripPrefix,
+			AddPrefix:    rc.AddPrefix,
+			SetHeaders:   rc.SetHeaders,
+			PreserveHost: rc.PreserveHost,
+			HostHeader:   rc.HostHeader,
+			response:     responseRewrite(rc.Rewrite),
+		}
+		if route.Name == "" {
+			route.Name = fmt.Sprintf("routes[%d]", i)
+		}
+		if route.Match == "" {
+			route.Match = MatchPrefix
+		}
+		if len(route.Targets) == 0 {
+			route.Targets = []string{rc.Target}
+		}
+		if route.Balance == "" {
+			route.Balance = BalanceRoundRobin
+		}
+		if err := route.compile(rc.HashHeader, rc.HealthCheck); err != nil {
+			return nil, err
+		}
+		if route.Policy, err = policy.New(rc.Policy); err != nil {
+			return nil, fmt.Errorf("路由%s配置有误: %v", route.Name, err)
+		}
+		router.routes = append(router.routes, route)
+	}
+
+	prefixes := make([]string, 0, len(cfg.URLMapping))
+	for k := range cfg.URLMapping {
+		prefixes = append(prefixes, k)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i]) != len(prefixes[j]) {
+			return len(prefixes[i]) > len(prefixes[j])
+		}
+		return prefixes[i] < prefixes[j]
+	})
+	for _, k := range prefixes {
+		route := &Route{
+			Name:    "urlMapping[" + k + "]",
+			Match:   MatchPrefix,
+			Path:    k,
+			Targets: []string{cfg.URLMapping[k]},
+		}
+		if err := route.compile("", nil); err != nil {
+			return nil, err
+		}
+		router.routes = append(router.routes, route)
+	}
+	return router, nil
+}
+
+// compile 检查路由规则并创建上游目标集合
+func (route *Route) compile(hashHeader string, check *config.HealthCheckConfig) error {
+	switch route.Match {
+	case MatchPrefix, MatchExact:
+	case MatchRegex:
+		regex, err := regexp.Compile(route.Path)
+		if err != nil {
+			return fmt.Errorf("路由%s的正则表达式有误: %v", route.Name, err)
+		}
+		route.regex = regex
+	default:
+		return fmt.Errorf("路由%s的匹配方式%s不支持", route.Name, route.Match)
+	}
+	if route.AddPrefix != "" && !strings.HasPrefix(route.AddPrefix, "/") {
+		return fmt.Errorf("路由%s的addPrefix需以/开头", route.Name)
+	}
+	pool, err := newPool(route.Targets, route.Balance, hashHeader, check)
+	if err != nil {
+		return fmt.Errorf("路由%s配置有误: %v", route.Name, err)
+	}
+	route.pool = pool
+	return nil
+}
+
+// Match 返回与请求匹配的路由规则、选择的上游及转发的URL，无匹配的路由规则时route为nil
+func (router *Router) Match(req *http.Request) (*Route, *Upstream, string, error) {
+	for _, route := range router.routes {
+		if !route.matches(req) {
+			continue
+		}
+		if _, ok := route.rewrite(req.RequestURI, ""); !ok {
+			continue
+		}
+		upstream, err := route.pool.pick(req)
+		if err != nil {
+			return route, nil, "", err
+		}
+		target, _ := route.rewrite(req.RequestURI, upstream.Target)
+		target, err = route.rewritePrefix(target)
+		return route, upstream, target, err
+	}
+	return nil, nil, "", nil
+}
+
+// Start 启动配置了健康检查的路由的上游检查
+func (router *Router) Start(transport http.RoundTripper) {
+	for _, route := range router.routes {
+		if route.pool.check != nil {
+			go route.pool.healthCheck(route.Name, transport)
+		}
+	}
+}
+
+// Routes 全部路由规则，按匹配顺序排列
+func (router *Router) Routes() []*Route {
+	return router.routes
+}
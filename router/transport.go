@@ -0,0 +1,27 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jamsa/hgap/config"
+)
+
+// NewTransport 创建所有路由共享的上游连接，复用空闲连接
+func NewTransport(cfg *config.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.UpstreamMaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.UpstreamMaxIdleConnsPerHost,
+		IdleConnTimeout:       time.Duration(cfg.UpstreamIdleTimeout) * time.Millisecond,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ResponseHeaderTimeout: time.Duration(cfg.Timeout) * time.Millisecond,
+	}
+}
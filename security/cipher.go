@@ -0,0 +1,184 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/jamsa/hgap/config"
+)
+
+const (
+	segmentSize = 64 * 1024 //单个加密段的最大明文长度
+	finalFlag   = 1 << 31   //段长度中标识最后一段的位
+	lengthMask  = finalFlag - 1
+)
+
+// Cipher AES-GCM加解密器，数据流按段加密，格式为 基础nonce+(段长度+密文)*
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// LoadCipher 根据配置创建加解密器，未启用加密时返回nil
+func LoadCipher(cfg *config.Config) (*Cipher, error) {
+	if !cfg.Encrypt {
+		return nil, nil
+	}
+	return NewCipher(cfg.KeyFile)
+}
+
+// NewCipher 从预共享密钥文件创建加解密器，文件内容经SHA-256摘要后作为AES-256密钥
+func NewCipher(keyFile string) (*Cipher, error) {
+	key, err := loadKey(keyFile, "")
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, pkgerrors.WithMessage(err, "创建AES加密器出错")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, pkgerrors.WithMessage(err, "创建GCM加密器出错")
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// segmentNonce 第counter段的nonce
+func segmentNonce(base []byte, counter uint64) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+	tail := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^counter)
+	return nonce
+}
+
+// segmentAAD 段的附加认证数据，包含reqID及段长度标识
+func segmentAAD(reqID string, header uint32) []byte {
+	aad := make([]byte, len(reqID)+4)
+	copy(aad, reqID)
+	binary.BigEndian.PutUint32(aad[len(reqID):], header)
+	return aad
+}
+
+// encryptWriter 加密写入器
+type encryptWriter struct {
+	cipher  *Cipher
+	reqID   string
+	w       io.Writer
+	nonce   []byte //基础nonce，未写出时为nil
+	counter uint64
+	buf     []byte
+}
+
+// NewEncryptWriter 创建加密写入器，reqID作为附加认证数据，Close时写出最后一段，不关闭w
+func (c *Cipher) NewEncryptWriter(reqID string, w io.Writer) io.WriteCloser {
+	return &encryptWriter{cipher: c, reqID: reqID, w: w}
+}
+
+func (writer *encryptWriter) Write(p []byte) (int, error) {
+	writer.buf = append(writer.buf, p...)
+	for len(writer.buf) > segmentSize {
+		if err := writer.seal(writer.buf[:segmentSize], false); err != nil {
+			return 0, err
+		}
+		writer.buf = writer.buf[segmentSize:]
+	}
+	return len(p), nil
+}
+
+func (writer *encryptWriter) Close() error {
+	err := writer.seal(writer.buf, true)
+	writer.buf = nil
+	return err
+}
+
+func (writer *encryptWriter) seal(plain []byte, final bool) error {
+	aead := writer.cipher.aead
+	if writer.nonce == nil {
+		writer.nonce = make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, writer.nonce); err != nil {
+			return err
+		}
+		if _, err := writer.w.Write(writer.nonce); err != nil {
+			return err
+		}
+	}
+	header := uint32(len(plain) + aead.Overhead())
+	if final {
+		header |= finalFlag
+	}
+	out := make([]byte, 4, 4+len(plain)+aead.Overhead())
+	binary.BigEndian.PutUint32(out, header)
+	out = aead.Seal(out, segmentNonce(writer.nonce, writer.counter), plain, segmentAAD(writer.reqID, header))
+	writer.counter++
+	_, err := writer.w.Write(out)
+	return err
+}
+
+// decryptReader 解密读取器
+type decryptReader struct {
+	cipher  *Cipher
+	reqID   string
+	r       io.Reader
+	nonce   []byte
+	counter uint64
+	plain   []byte //已解密未读取的数据
+	final   bool   //已读取最后一段
+}
+
+// NewDecryptReader 创建解密读取器，每段数据在通过完整性校验后才会返回，数据被截断时返回错误
+func (c *Cipher) NewDecryptReader(reqID string, r io.Reader) io.Reader {
+	return &decryptReader{cipher: c, reqID: reqID, r: r}
+}
+
+func (reader *decryptReader) Read(p []byte) (int, error) {
+	for len(reader.plain) == 0 {
+		if reader.final {
+			return 0, io.EOF
+		}
+		if err := reader.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, reader.plain)
+	reader.plain = reader.plain[n:]
+	return n, nil
+}
+
+func (reader *decryptReader) open() error {
+	aead := reader.cipher.aead
+	if reader.nonce == nil {
+		reader.nonce = make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(reader.r, reader.nonce); err != nil {
+			return fmt.Errorf("密文长度不足: %w", err)
+		}
+	}
+	var head [4]byte
+	if _, err := io.ReadFull(reader.r, head[:]); err != nil {
+		return fmt.Errorf("密文被截断: %w", err)
+	}
+	header := binary.BigEndian.Uint32(head[:])
+	size := int(header & lengthMask)
+	if size < aead.Overhead() || size > segmentSize+aead.Overhead() {
+		return errors.New("密文段长度错误")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(reader.r, sealed); err != nil {
+		return fmt.Errorf("密文被截断: %w", err)
+	}
+	plain, err := aead.Open(sealed[:0], segmentNonce(reader.nonce, reader.counter), sealed, segmentAAD(reader.reqID, header))
+	if err != nil {
+		return err
+	}
+	reader.counter++
+	reader.plain = plain
+	reader.final = header&finalFlag != 0
+	return nil
+}
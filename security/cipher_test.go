@@ -0,0 +1,152 @@
+package security
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+const testReqID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+func newTestCipher(t *testing.T, key string) *Cipher {
+	c, err := NewCipher(writeKey(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func encrypt(t *testing.T, c *Cipher, reqID string, data []byte) []byte {
+	var buf bytes.Buffer
+	w := c.NewEncryptWriter(reqID, &buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(c *Cipher, reqID string, data []byte) ([]byte, error) {
+	return ioutil.ReadAll(c.NewDecryptReader(reqID, bytes.NewReader(data)))
+}
+
+// segments 将密文拆分为基础nonce及各加密段
+func segments(c *Cipher, data []byte) ([]byte, [][]byte) {
+	nonceSize := c.aead.NonceSize()
+	nonce, rest := data[:nonceSize], data[nonceSize:]
+	var result [][]byte
+	for len(rest) > 0 {
+		size := 4 + int(binary.BigEndian.Uint32(rest)&lengthMask)
+		result = append(result, rest[:size])
+		rest = rest[size:]
+	}
+	return nonce, result
+}
+
+func join(nonce []byte, segs ...[]byte) []byte {
+	result := append([]byte(nil), nonce...)
+	for _, s := range segs {
+		result = append(result, s...)
+	}
+	return result
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	c := newTestCipher(t, "secret")
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 17} {
+		data := bytes.Repeat([]byte{0x5a}, size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		got, err := decrypt(c, testReqID, encrypt(t, c, testReqID, data))
+		if err != nil {
+			t.Fatalf("%d字节: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%d字节: 解密结果与原文不一致", size)
+		}
+	}
+}
+
+func TestCipherReject(t *testing.T) {
+	c := newTestCipher(t, "secret")
+	data := bytes.Repeat([]byte("0123456789abcdef"), segmentSize*3/16)
+	sealed := encrypt(t, c, testReqID, data)
+	nonce, segs := segments(c, sealed)
+	if len(segs) != 3 {
+		t.Fatalf("加密段数量%d, 期望3", len(segs))
+	}
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(nonce)+100] ^= 1
+
+	tests := []struct {
+		name   string
+		cipher *Cipher
+		reqID  string
+		data   []byte
+	}{
+		{"其它密钥", newTestCipher(t, "other"), testReqID, sealed},
+		{"其它请求标识", c, "00000000-0000-0000-0000-000000000000", sealed},
+		{"密文被篡改", c, testReqID, tampered},
+		{"截断最后一个字节", c, testReqID, sealed[:len(sealed)-1]},
+		{"缺少最后一段", c, testReqID, join(nonce, segs[0], segs[1])},
+		{"段顺序交换", c, testReqID, join(nonce, segs[1], segs[0], segs[2])},
+		{"重复的段", c, testReqID, join(nonce, segs[0], segs[0], segs[1], segs[2])},
+		{"只有nonce", c, testReqID, nonce},
+		{"nonce不完整", c, testReqID, nonce[:len(nonce)-1]},
+		{"空数据", c, testReqID, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decrypt(tt.cipher, tt.reqID, tt.data); err == nil {
+				t.Error("应返回错误")
+			}
+		})
+	}
+}
+
+func TestCipherFinalSegmentFlag(t *testing.T) {
+	c := newTestCipher(t, "secret")
+	sealed := encrypt(t, c, testReqID, []byte("data"))
+	nonce, segs := segments(c, sealed)
+	//清除最后一段的标识位后，段长度及AAD不匹配
+	last := append([]byte(nil), segs[len(segs)-1]...)
+	binary.BigEndian.PutUint32(last, binary.BigEndian.Uint32(last)&lengthMask)
+	if _, err := decrypt(c, testReqID, join(nonce, last)); err == nil {
+		t.Error("修改最后一段的标识后应返回错误")
+	}
+}
+
+// errReader 读取完数据后返回指定的错误
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (reader *errReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	if err == io.EOF {
+		return n, reader.err
+	}
+	return n, err
+}
+
+func TestCipherReadError(t *testing.T) {
+	c := newTestCipher(t, "secret")
+	sealed := encrypt(t, c, testReqID, bytes.Repeat([]byte("data"), segmentSize))
+	errLimit := errors.New("超出上限")
+	//底层读取器的错误应保留在返回的错误链中，以便调用方区分错误类型
+	for _, size := range []int{0, c.aead.NonceSize() + 2, c.aead.NonceSize() + 100} {
+		r := &errReader{r: bytes.NewReader(sealed[:size]), err: fmt.Errorf("%w: %d", errLimit, size)}
+		_, err := ioutil.ReadAll(c.NewDecryptReader(testReqID, r))
+		if !errors.Is(err, errLimit) {
+			t.Errorf("读取%d字节后返回%v, 期望包含%v", size, err, errLimit)
+		}
+	}
+}
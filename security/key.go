@@ -0,0 +1,25 @@
+package security
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+// loadKey 读取预共享密钥文件，并按用途派生出32字节的密钥
+func loadKey(keyFile string, purpose string) ([]byte, error) {
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, pkgerrors.WithMessagef(err, "读取密钥文件 %v 出错", keyFile)
+	}
+	content = bytes.TrimSpace(content)
+	if len(content) == 0 {
+		return nil, pkgerrors.Errorf("密钥文件 %v 内容为空", keyFile)
+	}
+	hash := sha256.New()
+	hash.Write([]byte(purpose))
+	hash.Write(content)
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,221 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/jamsa/hgap/config"
+)
+
+const (
+	timestampSize = 8  //时间戳长度
+	nonceSize     = 16 //随机数长度
+	macSize       = sha256.Size
+	// SignOverhead 签名增加的数据长度
+	SignOverhead = timestampSize + nonceSize + macSize
+)
+
+// 签名校验错误
+var (
+	ErrForged   = errors.New("签名校验失败，数据被伪造或篡改")
+	ErrExpired  = errors.New("时间戳超出允许范围")
+	ErrReplayed = errors.New("重复的随机数，疑似重放数据")
+)
+
+// Signer HMAC签名器，签名后的数据格式为 时间戳+随机数+数据+HMAC
+type Signer struct {
+	key       []byte
+	window    time.Duration        //时间戳允许的偏差
+	lock      sync.Mutex           //保护nonces
+	nonces    map[string]time.Time //已接收的随机数
+	lastPurge time.Time            //最后一次清理随机数的时间
+}
+
+// LoadSigner 根据配置创建签名器，未启用签名时返回nil
+func LoadSigner(cfg *config.Config) (*Signer, error) {
+	if !cfg.Sign {
+		return nil, nil
+	}
+	return NewSigner(cfg.KeyFile, time.Duration(cfg.ReplayWindow)*time.Millisecond)
+}
+
+// NewSigner 从预共享密钥文件创建签名器
+func NewSigner(keyFile string, window time.Duration) (*Signer, error) {
+	key, err := loadKey(keyFile, "hgap-sign")
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		key:       key,
+		window:    window,
+		nonces:    make(map[string]time.Time),
+		lastPurge: time.Now(),
+	}, nil
+}
+
+// header 生成时间戳及随机数
+func (signer *Signer) header() ([]byte, error) {
+	result := make([]byte, timestampSize+nonceSize)
+	binary.BigEndian.PutUint64(result, uint64(time.Now().UnixNano()))
+	if _, err := io.ReadFull(rand.Reader, result[timestampSize:]); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// check 校验时间戳及随机数
+func (signer *Signer) check(header []byte) error {
+	now := time.Now()
+	timestamp := time.Unix(0, int64(binary.BigEndian.Uint64(header)))
+	if timestamp.Before(now.Add(-signer.window)) || timestamp.After(now.Add(signer.window)) {
+		return ErrExpired
+	}
+	return signer.checkNonce(header)
+}
+
+// checkNonce 校验随机数
+func (signer *Signer) checkNonce(header []byte) error {
+	now := time.Now()
+
+	nonce := string(header[timestampSize : timestampSize+nonceSize])
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+	if now.Sub(signer.lastPurge) > signer.window {
+		for k, v := range signer.nonces {
+			if now.Sub(v) > signer.window*2 {
+				delete(signer.nonces, k)
+			}
+		}
+		signer.lastPurge = now
+	}
+	if _, ok := signer.nonces[nonce]; ok {
+		return ErrReplayed
+	}
+	signer.nonces[nonce] = now
+	return nil
+}
+
+// Sign 对数据签名
+func (signer *Signer) Sign(data []byte) ([]byte, error) {
+	header, err := signer.header()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 0, SignOverhead+len(data))
+	result = append(append(result, header...), data...)
+	return append(result, signer.mac(result)...), nil
+}
+
+// Verify 校验签名、时间戳及随机数，返回签名前的数据
+func (signer *Signer) Verify(data []byte) ([]byte, error) {
+	if len(data) < SignOverhead {
+		return nil, ErrForged
+	}
+	body := data[:len(data)-macSize]
+	if !hmac.Equal(signer.mac(body), data[len(body):]) {
+		return nil, ErrForged
+	}
+	if err := signer.check(body); err != nil {
+		return nil, err
+	}
+	return body[timestampSize+nonceSize:], nil
+}
+
+// signWriter 签名写入器
+type signWriter struct {
+	w   io.Writer
+	mac hash.Hash
+}
+
+// NewSignWriter 创建签名写入器，先写出时间戳及随机数，Close时写出HMAC，不关闭w
+func (signer *Signer) NewSignWriter(w io.Writer) (io.WriteCloser, error) {
+	header, err := signer.header()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, signer.key)
+	mac.Write(header)
+	return &signWriter{w: w, mac: mac}, nil
+}
+
+func (writer *signWriter) Write(p []byte) (int, error) {
+	writer.mac.Write(p)
+	return writer.w.Write(p)
+}
+
+func (writer *signWriter) Close() error {
+	_, err := writer.w.Write(writer.mac.Sum(nil))
+	return err
+}
+
+// tailWriter 保留最后size个字节，之前的数据写入w
+type tailWriter struct {
+	w    io.Writer
+	size int
+	tail []byte
+}
+
+func (writer *tailWriter) Write(p []byte) (int, error) {
+	writer.tail = append(writer.tail, p...)
+	if extra := len(writer.tail) - writer.size; extra > 0 {
+		writer.w.Write(writer.tail[:extra])
+		writer.tail = append(writer.tail[:0], writer.tail[extra:]...)
+	}
+	return len(p), nil
+}
+
+// VerifyReader 读取完整的数据流并校验签名、时间戳及随机数，返回签名前的数据长度
+func (signer *Signer) VerifyReader(r io.Reader) (int64, error) {
+	return signer.verifyReader(r, signer.check)
+}
+
+// VerifyStoredReader 校验重启前已保存的数据流，只校验签名及随机数，不检查时间戳
+func (signer *Signer) VerifyStoredReader(r io.Reader) (int64, error) {
+	return signer.verifyReader(r, signer.checkNonce)
+}
+
+func (signer *Signer) verifyReader(r io.Reader, check func([]byte) error) (int64, error) {
+	header := make([]byte, timestampSize+nonceSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, ErrForged
+	}
+	mac := hmac.New(sha256.New, signer.key)
+	mac.Write(header)
+	tail := &tailWriter{w: mac, size: macSize}
+	n, err := io.Copy(tail, r)
+	if err != nil {
+		return 0, err
+	}
+	if len(tail.tail) < macSize || !hmac.Equal(mac.Sum(nil), tail.tail) {
+		return 0, ErrForged
+	}
+	if err := check(header); err != nil {
+		return 0, err
+	}
+	return n - macSize, nil
+}
+
+// SignedPayload 跳过签名数据流的头部，返回长度为size的原始数据
+func SignedPayload(r io.Reader, size int64) (io.Reader, error) {
+	if _, err := io.CopyN(ioutil.Discard, r, timestampSize+nonceSize); err != nil {
+		return nil, err
+	}
+	return io.LimitReader(r, size), nil
+}
+
+func (signer *Signer) mac(data []byte) []byte {
+	h := hmac.New(sha256.New, signer.key)
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,193 @@
+package security
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKey 在临时目录中写入密钥文件
+func writeKey(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "hgap-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	keyFile := filepath.Join(dir, "hgap.key")
+	if err = ioutil.WriteFile(keyFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
+
+func newTestSigner(t *testing.T, key string) *Signer {
+	signer, err := NewSigner(writeKey(t, key), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// signAt 以指定的时间戳签名
+func signAt(signer *Signer, data []byte, timestamp time.Time) []byte {
+	signed, _ := signer.Sign(data)
+	binary.BigEndian.PutUint64(signed, uint64(timestamp.UnixNano()))
+	body := signed[:len(signed)-macSize]
+	return append(body, signer.mac(body)...)
+}
+
+func TestSignerVerify(t *testing.T) {
+	signer := newTestSigner(t, "secret")
+	other := newTestSigner(t, "other")
+	data := []byte("GET / HTTP/1.1\r\n\r\n")
+	modify := func(f func([]byte) []byte) []byte {
+		signed, _ := signer.Sign(data)
+		return f(signed)
+	}
+	tests := []struct {
+		name   string
+		signed []byte
+		err    error
+	}{
+		{"有效", modify(func(d []byte) []byte { return d }), nil},
+		{"空数据", func() []byte { d, _ := signer.Sign(nil); return d }(), nil},
+		{"其它密钥签名", func() []byte { d, _ := other.Sign(data); return d }(), ErrForged},
+		{"数据被篡改", modify(func(d []byte) []byte { d[timestampSize+nonceSize] ^= 1; return d }), ErrForged},
+		{"时间戳被篡改", modify(func(d []byte) []byte { d[0] ^= 1; return d }), ErrForged},
+		{"HMAC被篡改", modify(func(d []byte) []byte { d[len(d)-1] ^= 1; return d }), ErrForged},
+		{"被截断", modify(func(d []byte) []byte { return d[:len(d)-1] }), ErrForged},
+		{"长度不足", []byte("short"), ErrForged},
+		{"已过期", signAt(signer, data, time.Now().Add(-2*time.Minute)), ErrExpired},
+		{"时间超前", signAt(signer, data, time.Now().Add(2*time.Minute)), ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := signer.Verify(tt.signed)
+			if err != tt.err {
+				t.Fatalf("返回%v, 期望%v", err, tt.err)
+			}
+			if err == nil && len(got) > 0 && !bytes.Equal(got, data) {
+				t.Errorf("数据为%q, 期望%q", got, data)
+			}
+		})
+	}
+}
+
+func TestSignerReplay(t *testing.T) {
+	signer := newTestSigner(t, "secret")
+	signed, err := signer.Sign([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.Verify(signed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.Verify(signed); err != ErrReplayed {
+		t.Errorf("重放数据返回%v, 期望%v", err, ErrReplayed)
+	}
+}
+
+// signStream 使用签名写入器签名
+func signStream(t *testing.T, signer *Signer, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := signer.NewSignWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//分多次写入
+	for len(data) > 0 {
+		n := 7
+		if n > len(data) {
+			n = len(data)
+		}
+		w.Write(data[:n])
+		data = data[n:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSignerVerifyReader(t *testing.T) {
+	signer := newTestSigner(t, "secret")
+	other := newTestSigner(t, "other")
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	tamper := func(i int) []byte {
+		d := signStream(t, signer, data)
+		d[i] ^= 1
+		return d
+	}
+	tests := []struct {
+		name   string
+		signed []byte
+		err    error
+	}{
+		{"有效", signStream(t, signer, data), nil},
+		{"与Sign格式一致", func() []byte { d, _ := signer.Sign(data); return d }(), nil},
+		{"其它密钥签名", signStream(t, other, data), ErrForged},
+		{"数据被篡改", tamper(timestampSize + nonceSize + 500), ErrForged},
+		{"时间戳被篡改", tamper(1), ErrForged},
+		{"被截断", signStream(t, signer, data)[:timestampSize+nonceSize+len(data)], ErrForged},
+		{"只有头部", signStream(t, signer, data)[:timestampSize+nonceSize], ErrForged},
+		{"空数据", nil, ErrForged},
+		{"已过期", signAt(signer, data, time.Now().Add(-2*time.Minute)), ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, err := signer.VerifyReader(bytes.NewReader(tt.signed))
+			if err != tt.err {
+				t.Fatalf("返回%v, 期望%v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if size != int64(len(data)) {
+				t.Fatalf("数据长度%d, 期望%d", size, len(data))
+			}
+			payload, err := SignedPayload(bytes.NewReader(tt.signed), size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := ioutil.ReadAll(payload); !bytes.Equal(got, data) {
+				t.Errorf("读取的数据与签名前不一致")
+			}
+		})
+	}
+}
+
+func TestSignerVerifyReaderReplay(t *testing.T) {
+	signer := newTestSigner(t, "secret")
+	signed := signStream(t, signer, []byte("data"))
+	if _, err := signer.VerifyReader(bytes.NewReader(signed)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.VerifyReader(bytes.NewReader(signed)); err != ErrReplayed {
+		t.Errorf("重放数据返回%v, 期望%v", err, ErrReplayed)
+	}
+}
+
+func TestSignerVerifyStoredReader(t *testing.T) {
+	signer := newTestSigner(t, "secret")
+	data := []byte("data")
+	//启动前保存的数据不检查时间戳，仍校验签名及随机数
+	expired := signAt(signer, data, time.Now().Add(-time.Hour))
+	if _, err := signer.VerifyReader(bytes.NewReader(expired)); err != ErrExpired {
+		t.Fatalf("返回%v, 期望%v", err, ErrExpired)
+	}
+	if size, err := signer.VerifyStoredReader(bytes.NewReader(expired)); err != nil || size != int64(len(data)) {
+		t.Fatalf("返回%d, %v", size, err)
+	}
+	if _, err := signer.VerifyStoredReader(bytes.NewReader(expired)); err != ErrReplayed {
+		t.Errorf("重放数据返回%v, 期望%v", err, ErrReplayed)
+	}
+	forged := append([]byte(nil), expired...)
+	forged[len(forged)-1] ^= 1
+	if _, err := signer.VerifyStoredReader(bytes.NewReader(forged)); err != ErrForged {
+		t.Errorf("伪造数据返回%v, 期望%v", err, ErrForged)
+	}
+}
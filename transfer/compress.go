@@ -0,0 +1,122 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/textproto"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/packet"
+)
+
+// sniffSize 判断是否压缩时最多读取的Http头部长度
+const sniffSize = 64 * 1024
+
+// incompressibleTypes 已压缩的内容类型，以/结尾的表示该类型下的所有子类型
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/zstd",
+}
+
+// countWriter 统计写入的字节数
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// copyMessage 将消息写入w，先写入压缩标志，再写入压缩或原始的消息数据
+// 无论本端是否启用压缩都写入压缩标志，接收端按每个消息的标志解压，两端压缩配置不同时也能正确处理
+func (transfer *Transfer) copyMessage(reqID string, w io.Writer, reader io.Reader) error {
+	br := bufio.NewReaderSize(reader, sniffSize)
+	flag := packet.CompressNone
+	if transfer.compress && compressible(br) {
+		flag = packet.CompressGzip
+	}
+	if _, err := w.Write([]byte{flag}); err != nil {
+		return err
+	}
+	if flag == packet.CompressNone {
+		if transfer.compress {
+			log.Debug("消息内容已压缩，不再压缩:", reqID)
+		}
+		_, err := io.Copy(w, br)
+		return err
+	}
+
+	counter := &countWriter{w: w}
+	zw := gzip.NewWriter(counter)
+	n, err := io.Copy(zw, br)
+	if err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	ratio := 0.0
+	if n > 0 {
+		ratio = float64(counter.n) * 100 / float64(n)
+	}
+	log.Printf("压缩消息%s: %d -> %d字节, 压缩率%.1f%%", reqID, n, counter.n, ratio)
+	return nil
+}
+
+// compressible 根据Http头部判断消息是否需要压缩，内容已编码或为已压缩的类型时不压缩
+func compressible(br *bufio.Reader) bool {
+	var head []byte
+	for {
+		//每次至少多读取一个字节，流式的消息无需等待缓冲区填满
+		data, err := br.Peek(br.Buffered() + 1)
+		if i := bytes.Index(data, []byte("\r\n\r\n")); i >= 0 {
+			head = data[:i+4]
+			break
+		}
+		if err != nil {
+			return false
+		}
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(head)))
+	if _, err := tp.ReadLine(); err != nil {
+		return false
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return false
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return true
+	}
+	for _, t := range incompressibleTypes {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			//svg为文本格式
+			return mediaType == "image/svg+xml"
+		}
+	}
+	return true
+}
@@ -1,12 +1,19 @@
 package transfer
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/config"
+	"github.com/jamsa/hgap/janitor"
+	"github.com/jamsa/hgap/packet"
 )
 
 // FileTransfer 文件传输
@@ -16,28 +23,124 @@ type FileTransfer struct {
 	path     string //文件保存目录
 	fileExt  string //文件扩展名
 	keepFile bool   //保留传输的文件
+	manifest bool   //生成清单文件
 }
 
-// Send 发送文件
-func (transfer *FileTransfer) Send(reqID string, data []byte) {
-	eof := "EOF" + reqID
-	content := data
-	if transfer.textTransfer {
-		content = []byte(base64.StdEncoding.EncodeToString(data))
+// newFileTransfer 创建文件传输对象，并启动目录清理
+func newFileTransfer(transfer *Transfer, path string, fileExt string, cfg *config.Config) (*FileTransfer, error) {
+	fileJanitor, err := janitor.New(path, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if fileJanitor != nil {
+		go fileJanitor.Start()
+	}
+	return &FileTransfer{
+		Transfer: transfer,
+		path:     path,
+		fileExt:  fileExt,
+		keepFile: cfg.KeepFiles,
+		manifest: cfg.FileManifest,
+	}, nil
+}
+
+// Send 发送文件，数据先写入临时文件，写入完成后再重命名为正式文件名
+func (transfer *FileTransfer) Send(reqID string, reader io.Reader) error {
+	fileName := filepath.Join(transfer.path, reqID) + transfer.fileExt
+	tmpName := fileName + packet.TempExt
+	file, err := os.Create(tmpName)
+	if err != nil {
+		log.Error("创建请求文件出错", err)
+		return err
 	}
-	content = append(content, []byte(eof)...)
 
-	err := ioutil.WriteFile(filepath.Join(transfer.path, reqID)+transfer.fileExt, content, 0644)
+	hash := sha256.New()
+	err = transfer.writeFile(reqID, io.MultiWriter(file, hash), reader)
+	var size int64
+	if err == nil {
+		size, err = file.Seek(0, io.SeekCurrent)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		log.Error("写入请求文件出错", err)
-		return
+		os.Remove(tmpName)
+		return err
+	}
+
+	//清单文件需在数据文件就绪前生成
+	if transfer.manifest {
+		manifest := &packet.Manifest{
+			ID:     reqID,
+			Size:   size,
+			SHA256: hex.EncodeToString(hash.Sum(nil)),
+		}
+		if err = writeManifest(fileName+packet.ManifestExt, manifest); err != nil {
+			log.Error("写入清单文件出错", err)
+			os.Remove(tmpName)
+			return err
+		}
+	}
+	if err = os.Rename(tmpName, fileName); err != nil {
+		log.Error("重命名请求文件出错", err)
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// writeManifest 写入清单文件，同样先写入临时文件再重命名
+func writeManifest(fileName string, manifest *packet.Manifest) error {
+	data, err := manifest.Encode()
+	if err != nil {
+		return err
+	}
+	tmpName := fileName + packet.TempExt
+	if err = ioutil.WriteFile(tmpName, data, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, fileName); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// writeFile 将数据流编码后写入文件
+func (transfer *FileTransfer) writeFile(reqID string, file io.Writer, reader io.Reader) error {
+	var closers []io.Closer
+	var w io.Writer = file
+	if transfer.textTransfer {
+		text := base64.NewEncoder(base64.StdEncoding, w)
+		closers = append(closers, text)
+		w = text
+	}
+	signed, err := transfer.signWriter(w)
+	if err != nil {
+		return err
+	}
+	closers = append(closers, signed)
+	encoder := transfer.encoder(reqID, signed)
+	closers = append(closers, encoder)
+
+	if err = transfer.copyMessage(reqID, encoder, reader); err != nil {
+		return err
+	}
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err = closers[i].Close(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // Remove 删除文件
 func (transfer *FileTransfer) Remove(reqID string) {
 	if !transfer.keepFile {
 		log.Println("删除传输的文件" + reqID)
-		os.Remove(filepath.Join(transfer.path, reqID) + transfer.fileExt)
+		fileName := filepath.Join(transfer.path, reqID) + transfer.fileExt
+		os.Remove(fileName)
+		os.Remove(fileName + packet.ManifestExt)
 	}
 }
@@ -1,18 +1,56 @@
 package transfer
 
 import (
+	"errors"
+	"fmt"
+	"io"
+
 	log "github.com/sirupsen/logrus"
+
+	"github.com/jamsa/hgap/packet"
+	"github.com/jamsa/hgap/security"
 )
 
 // NetTransfer 传输
 type NetTransfer struct {
 	ITransfer
 	*Transfer
-	host string //服务器主机
-	port int    //服务器端口
+	host      string //服务器主机
+	port      int    //服务器端口
+	chunkSize int    //分组数据长度
+}
+
+// checkChunk 检查分组数据长度，udp传输时编码及签名后的分组不能超出UDP数据报上限
+func (transfer *NetTransfer) checkChunk(transferType string) error {
+	if transfer.chunkSize <= 0 {
+		return errors.New("分组数据长度必须大于0")
+	}
+	if transferType != "udp" {
+		return nil
+	}
+	size := transfer.chunkSize + packet.Overhead(packet.Version)
+	if transfer.signer != nil {
+		size += security.SignOverhead
+	}
+	if size > packet.MaxUDPSize {
+		return fmt.Errorf("分组数据长度%d超出UDP数据报上限%d", transfer.chunkSize, packet.MaxUDPSize)
+	}
+	return nil
 }
 
 // Remove 删除数据
 func (transfer *NetTransfer) Remove(reqID string) {
 	log.Println("删除传输的数据(NOP)：", reqID)
 }
+
+// write 将数据流编码后写入分组写入器
+func (transfer *NetTransfer) write(reqID string, writer *packet.Writer, reader io.Reader) error {
+	encoder := transfer.encoder(reqID, writer)
+	if err := transfer.copyMessage(reqID, encoder, reader); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return writer.Close()
+}
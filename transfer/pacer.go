@@ -0,0 +1,96 @@
+package transfer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pacer 令牌桶限速器，由同一Transfer的所有Send共享
+type pacer struct {
+	lock         sync.Mutex
+	byteRate     float64   //每秒发送字节数，0表示不限制
+	packetRate   float64   //每秒发送分组数，0表示不限制
+	byteTokens   float64   //可用的字节令牌
+	packetTokens float64   //可用的分组令牌
+	last         time.Time //最后一次补充令牌的时间
+	waiting      int32     //排队等待发送的分组数
+	statStart    time.Time //统计开始时间
+	statBytes    int       //统计周期内发送的字节数
+	statPackets  int       //统计周期内发送的分组数
+}
+
+// newPacer 创建限速器，两个速率均为0时返回nil
+func newPacer(byteRate int, packetRate int) *pacer {
+	if byteRate <= 0 && packetRate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &pacer{
+		byteRate:   float64(byteRate),
+		packetRate: float64(packetRate),
+		last:       now,
+		statStart:  now,
+	}
+}
+
+// refill 按流逝的时间补充令牌，令牌最多累积10ms的发送量，避免突发流量超出接收端缓冲区
+// 累积上限不小于一个size字节的分组，否则低速率时令牌永远不足以发送分组
+func (p *pacer) refill(now time.Time, size int) {
+	elapsed := now.Sub(p.last).Seconds()
+	p.last = now
+	p.byteTokens = fill(p.byteTokens, p.byteRate, elapsed, float64(size))
+	p.packetTokens = fill(p.packetTokens, p.packetRate, elapsed, 1)
+}
+
+func fill(tokens float64, rate float64, elapsed float64, least float64) float64 {
+	tokens += rate * elapsed
+	burst := rate / 100
+	if burst < least {
+		burst = least
+	}
+	if tokens > burst {
+		tokens = burst
+	}
+	return tokens
+}
+
+// wait 等待至可以发送size字节的分组
+func (p *pacer) wait(size int) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt32(&p.waiting, 1)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	atomic.AddInt32(&p.waiting, -1)
+
+	p.refill(time.Now(), size)
+	var delay float64
+	if p.byteRate > 0 && p.byteTokens < float64(size) {
+		delay = (float64(size) - p.byteTokens) / p.byteRate
+	}
+	if p.packetRate > 0 && p.packetTokens < 1 {
+		if d := (1 - p.packetTokens) / p.packetRate; d > delay {
+			delay = d
+		}
+	}
+	if delay > 0 {
+		time.Sleep(time.Duration(delay * float64(time.Second)))
+		p.refill(time.Now(), size)
+	}
+	p.byteTokens -= float64(size)
+	p.packetTokens--
+
+	p.statBytes += size
+	p.statPackets++
+	if elapsed := p.last.Sub(p.statStart).Seconds(); elapsed >= 1 {
+		log.Printf("UDP发送速率: %.0f B/s, %.0f 包/s, 排队分组: %d",
+			float64(p.statBytes)/elapsed, float64(p.statPackets)/elapsed, atomic.LoadInt32(&p.waiting))
+		p.statStart = p.last
+		p.statBytes = 0
+		p.statPackets = 0
+	}
+}
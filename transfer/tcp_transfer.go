@@ -2,7 +2,9 @@ package transfer
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -10,43 +12,93 @@ import (
 	"github.com/jamsa/hgap/packet"
 )
 
-// TCPTransfer 传输
+// TCPTransfer 传输，所有请求复用同一个长连接，各请求的数据帧交错发送
 type TCPTransfer struct {
 	NetTransfer
+	lock      sync.Mutex //保护conn，保证单个帧的完整写入
+	conn      net.Conn   //长连接，断开后为nil
+	heartbeat int        //心跳间隔(ms)
+	lastSend  time.Time  //最后一次发送帧的时间
+	once      sync.Once  //启动心跳
 }
 
-func sendFrame(conn net.Conn, frame *packet.Frame) error {
+// connect 建立连接，调用方需持有lock
+func (transfer *TCPTransfer) connect() (net.Conn, error) {
+	if transfer.conn != nil {
+		return transfer.conn, nil
+	}
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", transfer.host, transfer.port), time.Second*30)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("向%s建立TCP长连接", conn.RemoteAddr().String())
+	transfer.conn = conn
+	return conn, nil
+}
+
+// sendFrame 发送数据帧，连接失效时重新连接并重发一次
+func (transfer *TCPTransfer) sendFrame(frame *packet.Frame) error {
 	buf, err := frame.Encode()
 	if err != nil {
 		log.Error("TCP帧编码出错", err)
 		return err
 	}
-	_, err = conn.Write(buf)
-	if err != nil {
-		log.Error("TCP帧发送失败", err)
-		return err
+
+	transfer.lock.Lock()
+	defer transfer.lock.Unlock()
+	for retry := 0; retry < 2; retry++ {
+		var conn net.Conn
+		conn, err = transfer.connect()
+		if err != nil {
+			log.Error("连接TCP服务器失败", err)
+			return err
+		}
+		conn.SetWriteDeadline(time.Now().Add(time.Second * 30))
+		if _, err = conn.Write(buf); err == nil {
+			transfer.lastSend = time.Now()
+			return nil
+		}
+		log.Warn("TCP链路中断，重新连接", err)
+		conn.Close()
+		transfer.conn = nil
+	}
+	log.Error("TCP帧发送失败", err)
+	return err
+}
+
+// keepAlive 链路空闲时定时发送心跳帧
+func (transfer *TCPTransfer) keepAlive() {
+	interval := time.Duration(transfer.heartbeat) * time.Millisecond
+	for {
+		time.Sleep(interval)
+		transfer.lock.Lock()
+		idle := time.Since(transfer.lastSend) >= interval
+		transfer.lock.Unlock()
+		if idle {
+			transfer.sendFrame(&packet.Frame{
+				FrameType: packet.FrameTypeHEARTBEAT,
+				Length:    int32(0),
+				Data:      nil,
+			})
+		}
 	}
-	return nil
 }
 
 // Send 发送文件
-func (transfer *TCPTransfer) Send(reqID string, data []byte) {
+func (transfer *TCPTransfer) Send(reqID string, reader io.Reader) error {
 	log.Printf("向%v:%v发送:%v", transfer.host, transfer.port, reqID)
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", transfer.host, transfer.port), time.Second*30)
-	if err != nil {
-		log.Error("连接TCP服务器失败", err)
-		return
+	if transfer.heartbeat > 0 {
+		transfer.once.Do(func() { go transfer.keepAlive() })
 	}
-	//log.Printf("向%s建立tcp连接", fmt.Sprintf("%s:%d", transfer.host, transfer.port))
-	defer conn.Close()
 
-	iter := packet.NewIterator(reqID, data, packet.MTU*100)
-	for iter.HasNext() {
-		pack := iter.Next()
+	writer := packet.NewWriter(reqID, transfer.chunkSize, func(pack *packet.Packet) error {
 		data, err := pack.Encode()
+		if err == nil {
+			data, err = transfer.sign(data)
+		}
 		if err != nil {
 			log.Error("TCP包编码出错", err)
-			continue
+			return err
 		}
 
 		frame := &packet.Frame{
@@ -54,29 +106,16 @@ func (transfer *TCPTransfer) Send(reqID string, data []byte) {
 			Length:    int32(len(data)),
 			Data:      data,
 		}
-		err = sendFrame(conn, frame)
-		if err != nil {
-			continue
+		if err = transfer.sendFrame(frame); err != nil {
+			return err
 		}
-		//log.Printf("发送分组: %+v,%+v,%+v,%+v\n", pack.ID, pack.Length, pack.Begin, pack.Size)
 		log.Debugf("发送TCP帧数据，类型:%+v,长度:%+v,数据长:%v", frame.FrameType, frame.Length, len(data))
+		return nil
+	})
+	if err := transfer.write(reqID, writer, reader); err != nil {
+		log.Error("发送数据出错", reqID, err)
+		return err
 	}
-
-	//发送关闭通知
-	frame := &packet.Frame{
-		FrameType: packet.FrameTypeCLOSE,
-		Length:    int32(0),
-		Data:      nil,
-	}
-	sendFrame(conn, frame)
-	if err != nil {
-		return
-	}
-	log.Debugf("发送关闭通知:%s", reqID)
-
-	//等侍接收回应
-	//buf := make([]byte, 0, 1024)
-	//conn.Read(buf)
-	//time.Sleep(3)
-	log.Println("关闭传输连接", reqID)
+	log.Println("数据发送完成", reqID)
+	return nil
 }
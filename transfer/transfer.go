@@ -2,100 +2,203 @@ package transfer
 
 import (
 	"errors"
+	"io"
 
 	"github.com/jamsa/hgap/config"
+	"github.com/jamsa/hgap/fec"
+	"github.com/jamsa/hgap/packet"
+	"github.com/jamsa/hgap/security"
 )
 
 // ITransfer 数据传输器
 type ITransfer interface {
-	Send(string, []byte) //发送文件
-	Remove(string)       //删除文件
+	Send(string, io.Reader) error //发送数据
+	Remove(string)                //删除文件
 }
 
 // Transfer 数据传输器
 type Transfer struct {
 	//ITransfer
 	textTransfer bool
+	cipher       *security.Cipher //加密器，未启用加密时为nil
+	signer       *security.Signer //签名器，未启用签名时为nil
+	compress     bool             //压缩消息
+}
+
+// nopWriteCloser Close时不做任何处理的Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// encoder 创建发送数据的编码(加密)写入器，关闭编码器时不关闭w
+func (transfer *Transfer) encoder(reqID string, w io.Writer) io.WriteCloser {
+	if transfer.cipher == nil {
+		return nopWriteCloser{w}
+	}
+	return transfer.cipher.NewEncryptWriter(reqID, w)
+}
+
+// sign 对发送的数据包签名
+func (transfer *Transfer) sign(data []byte) ([]byte, error) {
+	if transfer.signer == nil {
+		return data, nil
+	}
+	return transfer.signer.Sign(data)
+}
+
+// signWriter 创建文件签名写入器，关闭时不关闭w
+func (transfer *Transfer) signWriter(w io.Writer) (io.WriteCloser, error) {
+	if transfer.signer == nil {
+		return nopWriteCloser{w}, nil
+	}
+	return transfer.signer.NewSignWriter(w)
 }
 
 // NewTransfer 创建数据传输对象
 func NewTransfer(inBound bool, cfg *config.Config) (ITransfer, error) {
 	var result ITransfer
+	cipher, err := security.LoadCipher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := security.LoadSigner(cfg)
+	if err != nil {
+		return nil, err
+	}
 	if inBound && cfg.InTransferType == "file" {
-		fileTransfer := FileTransfer{
-			Transfer: &Transfer{
-				textTransfer: cfg.InTextTransfer,
-			},
-			path:     cfg.InDirectory,
-			fileExt:  ".req",
-			keepFile: cfg.KeepFiles,
+		fileTransfer, err := newFileTransfer(&Transfer{
+			textTransfer: cfg.InTextTransfer,
+			cipher:       cipher,
+			signer:       signer,
+			compress:     cfg.Compress,
+		}, cfg.InDirectory, ".req", cfg)
+		if err != nil {
+			return nil, err
 		}
-		result = &fileTransfer
+		result = fileTransfer
 		return result, nil
 	}
 	if !inBound && cfg.OutTransferType == "file" {
-		fileTransfer := FileTransfer{
-			Transfer: &Transfer{
-				textTransfer: cfg.OutTextTransfer,
-			},
-			path:     cfg.OutDirectory,
-			fileExt:  ".resp",
-			keepFile: cfg.KeepFiles,
+		fileTransfer, err := newFileTransfer(&Transfer{
+			textTransfer: cfg.OutTextTransfer,
+			cipher:       cipher,
+			signer:       signer,
+			compress:     cfg.Compress,
+		}, cfg.OutDirectory, ".resp", cfg)
+		if err != nil {
+			return nil, err
 		}
-		result = &fileTransfer
+		result = fileTransfer
 		return result, nil
 	}
 	if inBound && cfg.InTransferType == "udp" {
 		fileTransfer := UDPTransfer{
-			NetTransfer{
+			NetTransfer: NetTransfer{
 				Transfer: &Transfer{
 					textTransfer: cfg.InTextTransfer,
+					cipher:       cipher,
+					signer:       signer,
+					compress:     cfg.Compress,
 				},
-				host: cfg.OutMonitorHost,
-				port: cfg.OutMonitorPort,
+				host:      cfg.OutMonitorHost,
+				port:      cfg.OutMonitorPort,
+				chunkSize: packet.ChunkSize("udp", cfg.InChunkSize),
 			},
+			fecData:   cfg.InFECDataShards,
+			fecParity: cfg.InFECParityShards,
+			pacer:     newPacer(cfg.InUDPByteRate, cfg.InUDPPacketRate),
+		}
+		if err := checkFEC(fileTransfer.fecData, fileTransfer.fecParity); err != nil {
+			return nil, err
+		}
+		if err := fileTransfer.checkChunk("udp"); err != nil {
+			return nil, err
 		}
 		result = &fileTransfer
 		return result, nil
 	}
 	if !inBound && cfg.OutTransferType == "udp" {
 		fileTransfer := UDPTransfer{
-			NetTransfer{
+			NetTransfer: NetTransfer{
 				Transfer: &Transfer{
 					textTransfer: cfg.OutTextTransfer,
+					cipher:       cipher,
+					signer:       signer,
+					compress:     cfg.Compress,
 				},
-				host: cfg.InMonitorHost,
-				port: cfg.InMonitorPort,
+				host:      cfg.InMonitorHost,
+				port:      cfg.InMonitorPort,
+				chunkSize: packet.ChunkSize("udp", cfg.OutChunkSize),
 			},
+			fecData:   cfg.OutFECDataShards,
+			fecParity: cfg.OutFECParityShards,
+			pacer:     newPacer(cfg.OutUDPByteRate, cfg.OutUDPPacketRate),
+		}
+		if err := checkFEC(fileTransfer.fecData, fileTransfer.fecParity); err != nil {
+			return nil, err
+		}
+		if err := fileTransfer.checkChunk("udp"); err != nil {
+			return nil, err
 		}
 		result = &fileTransfer
 		return result, nil
 	}
 	if inBound && cfg.InTransferType == "tcp" {
 		fileTransfer := TCPTransfer{
-			NetTransfer{
+			NetTransfer: NetTransfer{
 				Transfer: &Transfer{
 					textTransfer: cfg.InTextTransfer,
+					cipher:       cipher,
+					signer:       signer,
+					compress:     cfg.Compress,
 				},
-				host: cfg.OutMonitorHost,
-				port: cfg.OutMonitorPort,
+				host:      cfg.OutMonitorHost,
+				port:      cfg.OutMonitorPort,
+				chunkSize: packet.ChunkSize("tcp", cfg.InChunkSize),
 			},
+			heartbeat: cfg.TCPHeartbeatInterval,
+		}
+		if err := fileTransfer.checkChunk("tcp"); err != nil {
+			return nil, err
 		}
 		result = &fileTransfer
 		return result, nil
 	}
 	if !inBound && cfg.OutTransferType == "tcp" {
 		fileTransfer := TCPTransfer{
-			NetTransfer{
+			NetTransfer: NetTransfer{
 				Transfer: &Transfer{
 					textTransfer: cfg.OutTextTransfer,
+					cipher:       cipher,
+					signer:       signer,
+					compress:     cfg.Compress,
 				},
-				host: cfg.InMonitorHost,
-				port: cfg.InMonitorPort,
+				host:      cfg.InMonitorHost,
+				port:      cfg.InMonitorPort,
+				chunkSize: packet.ChunkSize("tcp", cfg.OutChunkSize),
 			},
+			heartbeat: cfg.TCPHeartbeatInterval,
+		}
+		if err := fileTransfer.checkChunk("tcp"); err != nil {
+			return nil, err
 		}
 		result = &fileTransfer
 		return result, nil
 	}
 	return nil, errors.New("无法创建Transfer")
 }
+
+// checkFEC 检查FEC分块配置
+func checkFEC(data int, parity int) error {
+	if parity <= 0 {
+		return nil
+	}
+	if data <= 0 || data > fec.MaxShards || parity > fec.MaxShards {
+		return errors.New("FEC分组数量配置超出范围")
+	}
+	return nil
+}
@@ -1,20 +1,25 @@
 package transfer
 
 import (
+	"io"
 	"net"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/jamsa/hgap/fec"
 	"github.com/jamsa/hgap/packet"
 )
 
 // UDPTransfer 传输
 type UDPTransfer struct {
 	NetTransfer
+	fecData   int    //FEC分块中的数据分组数
+	fecParity int    //FEC分块中的校验分组数，0表示不启用
+	pacer     *pacer //发送限速器，未限速时为nil
 }
 
 // Send 发送文件
-func (transfer *UDPTransfer) Send(reqID string, data []byte) {
+func (transfer *UDPTransfer) Send(reqID string, reader io.Reader) error {
 	log.Printf("向%v:%v发送:%v", transfer.host, transfer.port, reqID)
 	sip := net.ParseIP(transfer.host)
 	srcAddr := &net.UDPAddr{IP: net.IPv4zero, Port: 0}
@@ -22,31 +27,69 @@ func (transfer *UDPTransfer) Send(reqID string, data []byte) {
 	conn, err := net.DialUDP("udp", srcAddr, dstAddr)
 	if err != nil {
 		log.Error("连接UDP服务器失败", err)
-		return
+		return err
 	}
 	defer conn.Close()
 
-	iter := packet.NewIterator(reqID, data, packet.MTU)
-	for iter.HasNext() {
-		pack := iter.Next()
-		data, err := pack.Encode()
-		/*
-			var buf bytes.Buffer
-			enc := gob.NewEncoder(&buf)
-			//T ODO 错误处理
-			err := enc.Encode(pack)
-		*/
-		if err != nil {
-			log.Error("包编码出错", err)
-			continue
-		}
-		//len, err := conn.Write(buf.Bytes())
-		len, err := conn.Write(data)
-		if err != nil {
-			log.Error("包发送失败", err)
-			continue
+	var block []*packet.Packet
+	writer := packet.NewWriter(reqID, transfer.chunkSize, func(pack *packet.Packet) error {
+		transfer.sendPacket(conn, pack)
+		if transfer.fecParity > 0 {
+			block = append(block, pack)
+			if len(block) == transfer.fecData || pack.Length >= 0 {
+				transfer.sendParity(conn, block)
+				block = block[:0]
+			}
 		}
-		//time.Sleep(time.Duration)
-		log.Debugf("发送分组: %+v,%+v,%+v,%+v,%v\n", pack.ID, pack.Length, pack.Begin, pack.Size, len)
+		return nil
+	})
+	if err = transfer.write(reqID, writer, reader); err != nil {
+		log.Error("发送数据出错", reqID, err)
+		return err
+	}
+	return nil
+}
+
+// sendParity 计算并发送分块的FEC校验分组
+func (transfer *UDPTransfer) sendParity(conn *net.UDPConn, block []*packet.Packet) {
+	shards := make([][]byte, len(block))
+	for i, pack := range block {
+		shards[i] = make([]byte, transfer.chunkSize)
+		copy(shards[i], pack.Data)
+	}
+	parities, err := fec.Encode(shards, transfer.fecParity)
+	if err != nil {
+		log.Error("计算FEC校验分组出错", err)
+		return
+	}
+	for i, parity := range parities {
+		transfer.sendPacket(conn, &packet.Packet{
+			ID:     block[0].ID,
+			Length: block[len(block)-1].Length,
+			Begin:  block[0].Begin,
+			Size:   len(parity),
+			Data:   parity,
+			Parity: i + 1,
+			Shards: len(block),
+		})
+	}
+}
+
+// sendPacket 发送单个分组
+func (transfer *UDPTransfer) sendPacket(conn *net.UDPConn, pack *packet.Packet) {
+	data, err := pack.Encode()
+	if err == nil {
+		data, err = transfer.sign(data)
+	}
+	if err != nil {
+		log.Error("包编码出错", err)
+		return
+	}
+	transfer.pacer.wait(len(data))
+	n, err := conn.Write(data)
+	if err != nil {
+		log.Error("包发送失败", err)
+		return
 	}
+	log.Debugf("发送分组: %+v,%+v,%+v,%+v,%v,%v\n", pack.ID, pack.Length, pack.Begin, pack.Size, pack.Parity, n)
 }